@@ -0,0 +1,442 @@
+package mgostore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/gsingharoy/mgostore/lib"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Attachment is a file stored in GridFS, which a model references by a *Attachment field.
+Create and Update upload new attachments, reading models binds their attachments so that
+Open streams their content on demand, and Destroy removes them.
+The attachment tag selects the GridFS prefix, "fs" by default, and whether the content
+is encrypted with the CryptoConfig of the model:
+
+	type User struct {
+		ID     bson.ObjectId `bson:"_id,omitempty"`
+		Avatar *mgostore.Attachment `bson:"avatar" attachment:"avatars,encrypt,key=pii"`
+	}
+
+	user.Avatar = mgostore.NewAttachment("avatar.png", "image/png", file)
+	err := mgostore.Update(user)
+*/
+type Attachment struct {
+	// ID of the GridFS file
+	ID          bson.ObjectId `json:"id,omitempty" bson:"id,omitempty"`
+	Name        string        `json:"name" bson:"name"`
+	ContentType string        `json:"content_type,omitempty" bson:"content_type,omitempty"`
+	// Size of the content, before it was encrypted
+	Size int64 `json:"size" bson:"size"`
+	// Stored along with the GridFS file as well
+	Metadata  bson.M `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty" bson:"encrypted,omitempty"`
+
+	// content waiting to be uploaded
+	content io.Reader
+	// whether content has been read, it cannot be uploaded again
+	consumed bool
+	// where the content is stored, set when the attachment is bound to its model
+	files  fileStore
+	config *MongoConfig
+	prefix string
+	field  string
+	key    []byte
+	keyErr error
+	ad     []byte
+}
+
+/*
+NewAttachment returns an attachment whose content is streamed from r
+when the model referencing it is created or updated.
+*/
+func NewAttachment(name string, contentType string, r io.Reader) *Attachment {
+	return &Attachment{Name: name, ContentType: contentType, content: r}
+}
+
+// Open returns the content of a stored attachment, decrypted if needed. It must be closed
+func (a *Attachment) Open() (io.ReadCloser, error) {
+	if a.files == nil || a.ID == "" {
+		return nil, ErrAttachmentNotStored
+	}
+	if a.Encrypted && a.keyErr != nil {
+		return nil, a.keyErr
+	}
+	rc, err := a.files.openFile(a.config, a.prefix, a.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Encrypted {
+		return rc, nil
+	}
+	r, err := lib.NewAesGcmStreamReader(a.key, rc, a.ad)
+	if err != nil {
+		rc.Close()
+		return nil, &DecryptionError{Field: a.field, Err: err}
+	}
+	return &decryptingReader{r: r, c: rc, field: a.field}, nil
+}
+
+// decryptingReader reports failing authentication of an encrypted attachment as a DecryptionError
+type decryptingReader struct {
+	r     io.Reader
+	c     io.Closer
+	field string
+}
+
+func (dr *decryptingReader) Read(p []byte) (int, error) {
+	n, err := dr.r.Read(p)
+	if err == lib.ErrAuthenticationFailed {
+		err = &DecryptionError{Field: dr.field, Err: ErrCiphertextMismatch}
+	}
+	return n, err
+}
+
+func (dr *decryptingReader) Close() error {
+	return dr.c.Close()
+}
+
+/*
+fileStore stores the content of attachments.
+storeFile calls write with the writer of a new file with the ID, name, content type
+and metadata of the attachment, and aborts the file when write fails.
+*/
+type fileStore interface {
+	storeFile(config *MongoConfig, prefix string, a *Attachment, write func(w io.Writer) error) error
+	openFile(config *MongoConfig, prefix string, id bson.ObjectId) (io.ReadCloser, error)
+	removeFile(config *MongoConfig, prefix string, id bson.ObjectId) error
+}
+
+// attachmentTag is the parsed attachment tag of a field
+type attachmentTag struct {
+	prefix  string
+	encrypt bool
+	key     string
+}
+
+// parseAttachmentTag parses an attachment tag like "avatars,encrypt,key=pii"
+func parseAttachmentTag(tag string) attachmentTag {
+	parts := strings.Split(tag, ",")
+	at := attachmentTag{prefix: strings.TrimSpace(parts[0])}
+	if at.prefix == "" {
+		at.prefix = "fs"
+	}
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "encrypt":
+			at.encrypt = true
+		case strings.HasPrefix(part, "key="):
+			at.key = strings.TrimPrefix(part, "key=")
+		}
+	}
+	return at
+}
+
+// attachmentField is a *Attachment field of a model
+type attachmentField struct {
+	field reflect.StructField
+	tag   attachmentTag
+}
+
+// attachmentFields returns the *Attachment fields of the model type
+func attachmentFields(t reflect.Type) []attachmentField {
+	var fields []attachmentField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type == reflect.TypeOf(&Attachment{}) {
+			fields = append(fields, attachmentField{field: f, tag: parseAttachmentTag(f.Tag.Get("attachment"))})
+		}
+	}
+	return fields
+}
+
+// hasAttachments reports if the model has *Attachment fields
+func hasAttachments(m interface{}) bool {
+	t := reflect.TypeOf(m)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && len(attachmentFields(t)) > 0
+}
+
+// bindAttachments binds the attachments of a model, so that they can be opened and removed
+func bindAttachments(m Model, files fileStore) {
+	v := reflect.ValueOf(m).Elem()
+	id := modelIDString(m)
+	for _, af := range attachmentFields(v.Type()) {
+		a, _ := v.FieldByIndex(af.field.Index).Interface().(*Attachment)
+		if a == nil {
+			continue
+		}
+		name := fieldBSONName(af.field)
+		a.files = files
+		a.config = m.DBConfig()
+		a.prefix = af.tag.prefix
+		a.field = name
+		a.ad = associatedData(id, name)
+		a.key, a.keyErr = nil, nil
+		if af.tag.encrypt {
+			if a.config.CryptoConfig == nil {
+				a.keyErr = ErrMissingCryptoSecret
+			} else {
+				a.key, a.keyErr = a.config.CryptoConfig.key(af.tag.key)
+			}
+		}
+	}
+}
+
+// bindAllAttachments binds the attachments of the models of a Models
+func bindAllAttachments(models interface{}, files fileStore) {
+	if !hasAttachments(models) {
+		return
+	}
+	for _, p := range modelPointers(models) {
+		bindAttachments(p.Interface().(Model), files)
+	}
+}
+
+/*
+uploadAttachments stores the content of the new attachments of the model.
+The uploaded attachments are returned, so that they can be rolled back when the model
+cannot be saved. When an upload fails, the attachments uploaded before are rolled back.
+Attachments whose content was read by a failed upload fail with ErrAttachmentConsumed.
+*/
+func uploadAttachments(m Model, files fileStore) ([]*Attachment, error) {
+	if !hasAttachments(m) {
+		return nil, nil
+	}
+	bindAttachments(m, files)
+	v := reflect.ValueOf(m).Elem()
+	var uploaded []*Attachment
+	for _, af := range attachmentFields(v.Type()) {
+		a, _ := v.FieldByIndex(af.field.Index).Interface().(*Attachment)
+		if a != nil && a.consumed && a.ID == "" {
+			rollbackAttachments(uploaded)
+			return nil, ErrAttachmentConsumed
+		}
+		if a == nil || a.content == nil {
+			continue
+		}
+		if af.tag.encrypt && a.keyErr != nil {
+			rollbackAttachments(uploaded)
+			return nil, a.keyErr
+		}
+		a.ID = bson.NewObjectId()
+		a.Encrypted = af.tag.encrypt
+		err := files.storeFile(a.config, a.prefix, a, a.writeContent)
+		if err != nil {
+			a.ID, a.Encrypted, a.Size = "", false, 0
+			rollbackAttachments(uploaded)
+			return nil, err
+		}
+		a.content = nil
+		uploaded = append(uploaded, a)
+	}
+	return uploaded, nil
+}
+
+/*
+rollbackAttachments removes the files of attachments uploaded for a model which could not be saved,
+and resets them to not stored, so that a retry never saves a reference to a removed file.
+*/
+func rollbackAttachments(uploaded []*Attachment) {
+	removeAttachments(uploaded)
+	for _, a := range uploaded {
+		a.ID, a.Encrypted, a.Size = "", false, 0
+	}
+}
+
+// writeContent copies the content of the attachment to w, encrypting it if needed
+func (a *Attachment) writeContent(w io.Writer) error {
+	content := a.content
+	var dst io.Writer = w
+	var encrypter io.WriteCloser
+	if a.Encrypted {
+		var err error
+		if encrypter, err = lib.NewAesGcmStreamWriter(a.key, w, a.ad); err != nil {
+			return err
+		}
+		dst = encrypter
+	}
+	a.content, a.consumed = nil, true
+	n, err := io.Copy(dst, content)
+	if err != nil {
+		return err
+	}
+	if encrypter != nil {
+		if err = encrypter.Close(); err != nil {
+			return err
+		}
+	}
+	a.Size = n
+	return nil
+}
+
+/*
+replacedAttachments returns the bound attachments of the stored model which the model
+no longer references, as they were replaced or removed.
+*/
+func replacedAttachments(stored Model, m Model) []*Attachment {
+	sv := reflect.ValueOf(stored).Elem()
+	mv := reflect.ValueOf(m).Elem()
+	var replaced []*Attachment
+	for _, af := range attachmentFields(sv.Type()) {
+		old, _ := sv.FieldByIndex(af.field.Index).Interface().(*Attachment)
+		current, _ := mv.FieldByIndex(af.field.Index).Interface().(*Attachment)
+		if old != nil && old.ID != "" && (current == nil || current.ID != old.ID) {
+			replaced = append(replaced, old)
+		}
+	}
+	return replaced
+}
+
+// storedAttachments returns the bound attachments of a model which have been stored
+func storedAttachments(m Model) []*Attachment {
+	v := reflect.ValueOf(m).Elem()
+	var stored []*Attachment
+	for _, af := range attachmentFields(v.Type()) {
+		if a, _ := v.FieldByIndex(af.field.Index).Interface().(*Attachment); a != nil && a.ID != "" {
+			stored = append(stored, a)
+		}
+	}
+	return stored
+}
+
+// removeAttachments removes the files of bound attachments, returning the first error
+func removeAttachments(attachments []*Attachment) error {
+	var firstErr error
+	for _, a := range attachments {
+		err := a.files.removeFile(a.config, a.prefix, a.ID)
+		if err != nil && err != mgo.ErrNotFound && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// newModelOf returns a new, empty model of the type of m
+func newModelOf(m Model) Model {
+	return reflect.New(reflect.TypeOf(m).Elem()).Interface().(Model)
+}
+
+// gridFSFiles is the fileStore of the package-level functions, op is the operation the files belong to
+type gridFSFiles struct {
+	op *operation
+}
+
+func (g gridFSFiles) fileOperation(name string, config *MongoConfig, prefix string, id bson.ObjectId) *operation {
+	fop := newOperation(config, prefix+".files", nil)
+	fop.name = name
+	fop.filter = bson.M{"_id": id}
+	if g.op != nil {
+		fop.ctx = g.op.ctx
+		fop.writeConcern = g.op.writeConcern
+	}
+	return fop
+}
+
+func (g gridFSFiles) storeFile(config *MongoConfig, prefix string, a *Attachment, write func(w io.Writer) error) error {
+	// The content is read once, so the upload cannot be retried
+	return g.fileOperation("store_file", config, prefix, a.ID).run(func(c *mgo.Collection) error {
+		f, err := c.Database.GridFS(prefix).Create(a.Name)
+		if err != nil {
+			return err
+		}
+		f.SetId(a.ID)
+		f.SetContentType(a.ContentType)
+		if a.Metadata != nil {
+			f.SetMeta(a.Metadata)
+		}
+		if err = write(f); err != nil {
+			f.Abort()
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+func (g gridFSFiles) openFile(config *MongoConfig, prefix string, id bson.ObjectId) (io.ReadCloser, error) {
+	session, err := newSession(config)
+	if err != nil {
+		if session != nil {
+			session.Close()
+		}
+		return nil, wrapError(err)
+	}
+	f, err := session.DB(config.DBName).GridFS(prefix).OpenId(id)
+	if err != nil {
+		session.Close()
+		return nil, wrapError(err)
+	}
+	return &gridFileReader{GridFile: f, session: session}, nil
+}
+
+func (g gridFSFiles) removeFile(config *MongoConfig, prefix string, id bson.ObjectId) error {
+	op := g.fileOperation("remove_file", config, prefix, id)
+	op.idempotent = true
+	return op.run(func(c *mgo.Collection) error {
+		return c.Database.GridFS(prefix).RemoveId(id)
+	})
+}
+
+// gridFileReader closes the session of a GridFS file along with the file
+type gridFileReader struct {
+	*mgo.GridFile
+	session *mgo.Session
+}
+
+func (r *gridFileReader) Close() error {
+	err := r.GridFile.Close()
+	r.session.Close()
+	return err
+}
+
+// memoryFile is a file of a MemoryStore
+type memoryFile struct {
+	attachment Attachment
+	data       []byte
+}
+
+func (s *MemoryStore) storeFile(config *MongoConfig, prefix string, a *Attachment, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	s.mux.Lock()
+	s.files[memoryFileKey(config, prefix, a.ID)] = &memoryFile{attachment: *a, data: buf.Bytes()}
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) openFile(config *MongoConfig, prefix string, id bson.ObjectId) (io.ReadCloser, error) {
+	s.mux.RLock()
+	f := s.files[memoryFileKey(config, prefix, id)]
+	s.mux.RUnlock()
+	if f == nil {
+		return nil, ErrRecordNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (s *MemoryStore) removeFile(config *MongoConfig, prefix string, id bson.ObjectId) error {
+	key := memoryFileKey(config, prefix, id)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.files[key] == nil {
+		return ErrRecordNotFound
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func memoryFileKey(config *MongoConfig, prefix string, id bson.ObjectId) string {
+	return config.DBName + "." + prefix + "/" + id.Hex()
+}
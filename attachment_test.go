@@ -0,0 +1,140 @@
+package mgostore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type attachmentModel struct {
+	ID       bson.ObjectId `bson:"_id,omitempty"`
+	Avatar   *Attachment   `bson:"avatar"`
+	Passport *Attachment   `bson:"passport" attachment:"documents,encrypt,key=pii"`
+}
+
+func (m *attachmentModel) CollectionName() string { return "attachment_models" }
+
+func (m *attachmentModel) DBConfig() *MongoConfig {
+	config := testMongoConfig()
+	config.CryptoConfig.Keys = map[string][]byte{"pii": []byte(testPIIEncryptionSecret)}
+	return config
+}
+
+func readAttachment(t *testing.T, a *Attachment) string {
+	rc, err := a.Open()
+	assert.Nil(t, err)
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func Test_parseAttachmentTag(t *testing.T) {
+	assert.Equal(t, attachmentTag{prefix: "fs"}, parseAttachmentTag(""))
+	assert.Equal(t, attachmentTag{prefix: "documents", encrypt: true, key: "pii"}, parseAttachmentTag("documents,encrypt,key=pii"))
+}
+
+func TestAttachments(t *testing.T) {
+	setTestEnvVars()
+	s := NewMemoryStore()
+
+	t.Log("When a model with attachments is created")
+	m := &attachmentModel{
+		Avatar:   NewAttachment("avatar.png", "image/png", strings.NewReader("avatar content")),
+		Passport: NewAttachment("passport.pdf", "application/pdf", strings.NewReader("passport content")),
+	}
+	m.Avatar.Metadata = bson.M{"width": 64}
+	assert.Nil(t, s.Create(m))
+	assert.NotEqual(t, bson.ObjectId(""), m.Passport.ID, "Expected the attachment to be stored")
+	assert.Equal(t, int64(16), m.Passport.Size, "Expected the size of the content")
+	assert.True(t, m.Passport.Encrypted)
+	assert.False(t, m.Avatar.Encrypted)
+	assert.Equal(t, 2, len(s.files))
+	stored := s.files[memoryFileKey(m.DBConfig(), "documents", m.Passport.ID)]
+	assert.False(t, bytes.Contains(stored.data, []byte("passport content")), "Expected the content to be encrypted")
+	assert.Equal(t, bson.M{"width": 64}, s.files[memoryFileKey(m.DBConfig(), "fs", m.Avatar.ID)].attachment.Metadata)
+
+	t.Log("When the model is read")
+	found := &attachmentModel{ID: m.ID}
+	assert.Nil(t, s.Find(found))
+	assert.Equal(t, "avatar.png", found.Avatar.Name)
+	assert.Equal(t, "image/png", found.Avatar.ContentType)
+	assert.Equal(t, "avatar content", readAttachment(t, found.Avatar))
+	assert.Equal(t, "passport content", readAttachment(t, found.Passport), "Expected the content to be decrypted")
+
+	t.Log("When the encrypted content is moved to another model")
+	other := &attachmentModel{ID: bson.NewObjectId(), Passport: found.Passport}
+	bindAttachments(other, s)
+	rc, _ := other.Passport.Open()
+	_, err := ioutil.ReadAll(rc)
+	assert.True(t, errors.Is(err, ErrDecryption), "Expected a decryption error")
+
+	t.Log("When an attachment is replaced")
+	oldID := found.Avatar.ID
+	found.Avatar = NewAttachment("new.png", "image/png", strings.NewReader("new content"))
+	assert.Nil(t, s.Update(found))
+	assert.Equal(t, "new content", readAttachment(t, found.Avatar))
+	assert.Equal(t, 2, len(s.files), "Expected the replaced file to be removed")
+	assert.Nil(t, s.files[memoryFileKey(m.DBConfig(), "fs", oldID)])
+
+	t.Log("When an attachment is not stored")
+	_, err = NewAttachment("a.txt", "text/plain", strings.NewReader("a")).Open()
+	assert.Equal(t, ErrAttachmentNotStored, err)
+
+	t.Log("When the model is destroyed")
+	assert.Nil(t, s.Destroy(found))
+	assert.Equal(t, 0, len(s.files), "Expected the files to be removed")
+}
+
+func TestAttachmentsMissingKey(t *testing.T) {
+	setTestEnvVars()
+	s := NewMemoryStore()
+	m := &mockAttachmentModelWithoutKey{Passport: NewAttachment("passport.pdf", "application/pdf", strings.NewReader("content"))}
+	assert.Equal(t, ErrMissingCryptoSecret, s.Create(m))
+	assert.Equal(t, 0, len(s.files))
+}
+
+func TestAttachmentsRollback(t *testing.T) {
+	setTestEnvVars()
+	s := NewMemoryStore()
+	m := &attachmentModel{
+		ID:       bson.NewObjectId(),
+		Passport: NewAttachment("passport.pdf", "application/pdf", strings.NewReader("passport content")),
+	}
+	uploaded, err := uploadAttachments(m, s)
+	assert.Nil(t, err)
+
+	t.Log("When the model could not be saved")
+	rollbackAttachments(uploaded)
+	assert.Equal(t, 0, len(s.files), "Expected the file to be removed")
+	assert.Equal(t, bson.ObjectId(""), m.Passport.ID, "Expected the attachment to not be stored")
+	assert.False(t, m.Passport.Encrypted)
+	assert.Equal(t, int64(0), m.Passport.Size)
+
+	t.Log("When the save is retried with the read content")
+	_, err = uploadAttachments(m, s)
+	assert.Equal(t, ErrAttachmentConsumed, err)
+	assert.Equal(t, 0, len(s.files))
+
+	t.Log("When the save is retried with a new attachment")
+	m.Passport = NewAttachment("passport.pdf", "application/pdf", strings.NewReader("passport content"))
+	_, err = uploadAttachments(m, s)
+	assert.Nil(t, err)
+	assert.Equal(t, "passport content", readAttachment(t, m.Passport))
+}
+
+type mockAttachmentModelWithoutKey struct {
+	ID       bson.ObjectId `bson:"_id,omitempty"`
+	Passport *Attachment   `bson:"passport" attachment:"documents,encrypt"`
+}
+
+func (m *mockAttachmentModelWithoutKey) CollectionName() string { return "attachment_models" }
+
+func (m *mockAttachmentModelWithoutKey) DBConfig() *MongoConfig {
+	return &MongoConfig{DBName: "mgostore_test"}
+}
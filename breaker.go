@@ -0,0 +1,175 @@
+package mgostore
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+// States of a circuit breaker
+const (
+	// Operations pass, failures are counted
+	CircuitClosed CircuitState = iota
+	// Operations fail fast with ErrCircuitOpen
+	CircuitOpen
+	// A single probe operation passes to check if the cluster has recovered
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+/*
+CircuitBreakerConfig configures the circuit breaker of a connection.
+The breaker opens after FailureThreshold consecutive transient failures, so that operations
+fail fast with ErrCircuitOpen instead of waiting for the Timeout of the config.
+After OpenTimeout it half-opens and lets one operation probe whether the cluster has recovered.
+*/
+type CircuitBreakerConfig struct {
+	// Consecutive failures after which the breaker opens
+	FailureThreshold int
+	// Time the breaker stays open before it lets a probe pass
+	OpenTimeout time.Duration
+	// Called on every change of state with the servers of the connection, without credentials
+	OnStateChange func(servers string, from CircuitState, to CircuitState)
+}
+
+// circuitBreaker guards the operations of one connection
+type circuitBreaker struct {
+	config  *CircuitBreakerConfig
+	servers string
+
+	mux      sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+/*
+allow reports if an operation may pass. An operation which was allowed has to
+report its outcome with done.
+*/
+func (cb *circuitBreaker) allow() bool {
+	cb.mux.Lock()
+	var from CircuitState
+	changed := false
+	allowed := true
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.config.OpenTimeout {
+			allowed = false
+			break
+		}
+		from, changed = cb.state, true
+		cb.state = CircuitHalfOpen
+		cb.probing = true
+	case CircuitHalfOpen:
+		if cb.probing {
+			allowed = false
+			break
+		}
+		cb.probing = true
+	}
+	cb.mux.Unlock()
+	if changed {
+		cb.notify(from, CircuitHalfOpen)
+	}
+	return allowed
+}
+
+/*
+done records the outcome of an operation. Only transient errors count as failures,
+any other outcome shows that the cluster is reachable.
+*/
+func (cb *circuitBreaker) done(err error) {
+	failed := IsTransient(err)
+	cb.mux.Lock()
+	from := cb.state
+	switch {
+	case cb.state == CircuitHalfOpen:
+		cb.probing = false
+		if failed {
+			cb.state = CircuitOpen
+			cb.openedAt = time.Now()
+		} else {
+			cb.state = CircuitClosed
+			cb.failures = 0
+		}
+	case failed:
+		cb.failures++
+		if cb.state == CircuitClosed && cb.failures >= cb.config.FailureThreshold {
+			cb.state = CircuitOpen
+			cb.openedAt = time.Now()
+		}
+	default:
+		cb.failures = 0
+	}
+	to := cb.state
+	cb.mux.Unlock()
+	if from != to {
+		cb.notify(from, to)
+	}
+}
+
+// notify calls the callback of the config, without holding the lock
+func (cb *circuitBreaker) notify(from CircuitState, to CircuitState) {
+	if cb.config.OnStateChange != nil {
+		cb.config.OnStateChange(cb.servers, from, to)
+	}
+}
+
+// breakerKey identifies the breaker of a connection with the settings of a config
+type breakerKey struct {
+	connection       string
+	failureThreshold int
+	openTimeout      time.Duration
+}
+
+/*
+fetchCircuitBreaker returns the breaker of the connection of the config, or nil when
+the config has none. Configs with other thresholds or timeouts get breakers of their own.
+Configs which only differ in OnStateChange share a breaker, which notifies the callback
+of the config it was created with.
+Breakers outlive failed dials, which is when they are needed most. They are removed along
+with the master session by Close, CloseAll and RefreshCredentials.
+*/
+func fetchCircuitBreaker(config *MongoConfig) *circuitBreaker {
+	if config.CircuitBreaker == nil {
+		return nil
+	}
+	key := breakerKey{
+		connection:       connectionKey(config),
+		failureThreshold: config.CircuitBreaker.FailureThreshold,
+		openTimeout:      config.CircuitBreaker.OpenTimeout,
+	}
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	cb, ok := breakers[key]
+	if !ok {
+		servers, _ := splitServersUserInfo(config.Servers)
+		cb = &circuitBreaker{config: config.CircuitBreaker, servers: servers}
+		breakers[key] = cb
+	}
+	return cb
+}
+
+// deleteCircuitBreakers removes the breakers of a connection, registryMux must be held
+func deleteCircuitBreakers(connection string) {
+	for key := range breakers {
+		if key.connection == connection {
+			delete(breakers, key)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package mgostore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	var changes []CircuitState
+	cb := &circuitBreaker{
+		servers: "localhost",
+		config: &CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenTimeout:      20 * time.Millisecond,
+			OnStateChange: func(servers string, from CircuitState, to CircuitState) {
+				assert.Equal(t, "localhost", servers)
+				changes = append(changes, to)
+			},
+		},
+	}
+	transientErr := errors.New("no reachable servers")
+
+	t.Log("When operations fail with permanent errors")
+	assert.True(t, cb.allow())
+	cb.done(mgo.ErrNotFound)
+	assert.Equal(t, CircuitClosed, cb.state, "Expected permanent errors to not open the breaker")
+
+	t.Log("When operations fail with transient errors")
+	cb.allow()
+	cb.done(transientErr)
+	assert.Equal(t, CircuitClosed, cb.state, "Expected the breaker to stay closed below the threshold")
+	cb.allow()
+	cb.done(transientErr)
+	assert.Equal(t, CircuitOpen, cb.state, "Expected the breaker to open at the threshold")
+	assert.False(t, cb.allow(), "Expected operations to fail fast")
+
+	t.Log("When the open timeout has passed")
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, cb.allow(), "Expected a probe to pass")
+	assert.Equal(t, CircuitHalfOpen, cb.state)
+	assert.False(t, cb.allow(), "Expected only one probe to pass")
+
+	t.Log("When the probe fails")
+	cb.done(transientErr)
+	assert.Equal(t, CircuitOpen, cb.state, "Expected the breaker to open again")
+
+	t.Log("When the probe succeeds")
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, cb.allow())
+	cb.done(nil)
+	assert.Equal(t, CircuitClosed, cb.state, "Expected the breaker to close")
+
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, changes)
+}
+
+func Test_operationCircuitBreaker(t *testing.T) {
+	config := &MongoConfig{
+		Servers:        "breaker_invalid_server",
+		Timeout:        10 * time.Millisecond,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute},
+	}
+	defer Close(config)
+	fn := func(c *mgo.Collection) error {
+		return nil
+	}
+
+	err := newOperation(config, "mock_models", nil).run(fn)
+	assert.Equal(t, "no reachable servers", err.Error())
+
+	t.Log("When the breaker is open")
+	start := time.Now()
+	err = newOperation(config, "mock_models", nil).run(fn)
+	assert.Equal(t, ErrCircuitOpen, err, "Expected the operation to fail fast")
+	assert.True(t, time.Since(start) < 10*time.Millisecond, "Expected to not wait for the timeout")
+
+	t.Log("When another config has other breaker settings")
+	other := *config
+	other.CircuitBreaker = &CircuitBreakerConfig{FailureThreshold: 5, OpenTimeout: time.Minute}
+	assert.False(t, fetchCircuitBreaker(&other) == fetchCircuitBreaker(config), "Expected a breaker of its own")
+
+	t.Log("When the connection is closed")
+	Close(config)
+	err = newOperation(config, "mock_models", nil).run(fn)
+	assert.Equal(t, "no reachable servers", err.Error(), "Expected the breaker to be removed")
+}
@@ -0,0 +1,167 @@
+package mgostore
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Cache holds documents read by Find, keyed by their collection and ID.
+The values are the bson documents as stored, so encrypted fields stay encrypted in the cache.
+Implementations need to be safe for concurrent use.
+*/
+type Cache interface {
+	// Get returns the cached document, or false on a miss
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+/*
+CachedModel is a model whose documents are cached by Find.
+The cache is consulted before the DB and populated on a miss. Update and Destroy invalidate
+the document, changes by other processes are only seen once the entry expired, unless
+they are invalidated eg. from the events of Watch.
+
+	var userCache = mgostore.NewLRUCache(10000, time.Minute)
+
+	func (u *User) Cache() mgostore.Cache { return userCache }
+*/
+type CachedModel interface {
+	Model
+	Cache() Cache
+}
+
+// SkipCache makes Find read from the DB, the document read is cached nonetheless
+func SkipCache() Option {
+	return func(op *operation) {
+		op.skipCache = true
+	}
+}
+
+// modelCache returns the cache of the model, or nil when it is not cached
+func modelCache(m Model) Cache {
+	cm, ok := m.(CachedModel)
+	if !ok {
+		return nil
+	}
+	return cm.Cache()
+}
+
+// cacheKey returns the key of the document with the ID in the collection of the model
+func cacheKey(m Model, id interface{}) string {
+	return collectionCacheKey(m.DBConfig().DBName, m.CollectionName(), id)
+}
+
+// collectionCacheKey returns the key of the document with the ID in the collection of the DB
+func collectionCacheKey(dbName string, collectionName string, id interface{}) string {
+	return dbName + "." + collectionName + "/" + idString(id)
+}
+
+// invalidateCache removes the document with the ID from the cache of the model
+func invalidateCache(m Model, id interface{}) {
+	if cm, ok := m.(CachedModel); ok && cm.Cache() != nil {
+		cm.Cache().Delete(cacheKey(m, id))
+	}
+}
+
+/*
+loadCached decodes a cached document into the model.
+The document is copied first, as decoded byte slices share the memory of the document.
+*/
+func loadCached(m Model, data []byte) error {
+	doc := make([]byte, len(data))
+	copy(doc, data)
+	return bson.Unmarshal(doc, m)
+}
+
+/*
+LRUCache is an in-process Cache which keeps up to a number of documents for a time to live.
+The least recently used document is evicted first.
+*/
+type LRUCache struct {
+	mux     sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries *list.List
+	items   map[string]*list.Element
+	now     func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+/*
+NewLRUCache returns a cache of up to size documents, which expire after the ttl.
+Documents do not expire when the ttl is 0.
+*/
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		items:   map[string]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+// Get returns the document unless it is missing or expired
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.entries.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set caches the document, evicting the least recently used one when the cache is full
+func (c *LRUCache) Set(key string, value []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.entries.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.entries.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.size > 0 && c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+	}
+}
+
+// Delete removes the document
+func (c *LRUCache) Delete(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of cached documents, including expired ones which were not evicted yet
+func (c *LRUCache) Len() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.entries.Len()
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.entries.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
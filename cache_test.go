@@ -0,0 +1,151 @@
+package mgostore
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var testModelCache = NewLRUCache(10, time.Minute)
+
+type cachedModel struct {
+	ID     bson.ObjectId `bson:"_id,omitempty"`
+	Name   string        `bson:"name"`
+	Secret string        `bson:"secret" encrypt:"aes"`
+}
+
+func (m *cachedModel) CollectionName() string { return "cached_models" }
+
+func (m *cachedModel) DBConfig() *MongoConfig { return testMongoConfig() }
+
+func (m *cachedModel) Cache() Cache { return testModelCache }
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	c := NewLRUCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	t.Log("When a document is cached")
+	c.Set("a", []byte("1"))
+	v, ok := c.Get("a")
+	assert.True(t, ok, "Expected a hit")
+	assert.Equal(t, []byte("1"), v)
+	_, ok = c.Get("b")
+	assert.False(t, ok, "Expected a miss")
+
+	t.Log("When the cache is full")
+	c.Set("b", []byte("2"))
+	c.Get("a")
+	c.Set("c", []byte("3"))
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get("b")
+	assert.False(t, ok, "Expected the least recently used document to be evicted")
+	_, ok = c.Get("a")
+	assert.True(t, ok, "Expected the recently used document to be kept")
+
+	t.Log("When a document is replaced")
+	c.Set("a", []byte("4"))
+	v, _ = c.Get("a")
+	assert.Equal(t, []byte("4"), v)
+	assert.Equal(t, 2, c.Len())
+
+	t.Log("When a document expired")
+	now = now.Add(time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok, "Expected an expired document to be a miss")
+	assert.Equal(t, 1, c.Len(), "Expected the expired document to be evicted")
+
+	t.Log("When a document is deleted")
+	c.Delete("c")
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestFindCached(t *testing.T) {
+	setTestEnvVars()
+	// Cache hits do not need a connection
+	os.Setenv("MONGODB_SERVERS", "cache_invalid_server")
+	defer setTestEnvVars()
+	stored := &cachedModel{ID: bson.NewObjectId(), Name: "cached", Secret: "secret value"}
+	assert.Nil(t, encryptFields(stored))
+	data, _ := bson.Marshal(stored)
+	key := cacheKey(stored, stored.ID)
+	testModelCache.Set(key, data)
+	defer testModelCache.Delete(key)
+
+	t.Log("When the document is cached")
+	m := &cachedModel{ID: stored.ID}
+	assert.Nil(t, Find(m), "Expected the document to be read from the cache")
+	assert.Equal(t, "cached", m.Name)
+	assert.Equal(t, "secret value", m.Secret, "Expected the cached document to be decrypted")
+	cachedData, _ := testModelCache.Get(key)
+	assert.Equal(t, data, cachedData, "Expected the cache to keep the encrypted document")
+
+	t.Log("When the cache is skipped")
+	assert.NotNil(t, Find(&cachedModel{ID: stored.ID}, SkipCache()), "Expected the DB to be read")
+
+	t.Log("When the cached document cannot be decrypted")
+	undecryptable, _ := bson.Marshal(&cachedModel{ID: stored.ID, Name: "cached", Secret: "not a ciphertext"})
+	testModelCache.Set(key, undecryptable)
+	m = &cachedModel{ID: stored.ID}
+	err := Find(m)
+	assert.NotNil(t, err, "Expected the DB to be read")
+	assert.False(t, errors.Is(err, ErrDecryption), "Expected the cached document to not be returned as an error")
+	_, ok := testModelCache.Get(key)
+	assert.False(t, ok, "Expected the cached document to be removed")
+	assert.Equal(t, "", m.Name, "Expected the cached document to not be left in the model")
+	assert.Equal(t, stored.ID, m.ID)
+	testModelCache.Set(key, data)
+
+	t.Log("When the model is destroyed")
+	Destroy(&cachedModel{ID: stored.ID})
+	_, ok = testModelCache.Get(key)
+	assert.False(t, ok, "Expected the document to be invalidated")
+
+	t.Log("When the model is updated")
+	testModelCache.Set(key, data)
+	Update(&cachedModel{ID: stored.ID, Name: "updated"})
+	_, ok = testModelCache.Get(key)
+	assert.False(t, ok, "Expected the document to be invalidated")
+}
+
+func TestFindCache(t *testing.T) {
+	setTestEnvVars()
+	t.Log("When connection can be established")
+	m := &cachedModel{Name: "cached", Secret: "secret value"}
+	s, _ := newSession(m.DBConfig())
+	tc := s.DB(m.DBConfig().DBName).C(m.CollectionName())
+	// Make sure to drop the entire collection after the test is run
+	defer DropCollection(m)
+	assert.Nil(t, Create(m))
+	key := cacheKey(m, m.ID)
+	defer testModelCache.Delete(key)
+
+	data, ok := testModelCache.Get(key)
+	assert.True(t, ok, "Expected the document to be cached by the Find of Create")
+	var doc bson.M
+	bson.Unmarshal(data, &doc)
+	assert.NotEqual(t, "secret value", doc["secret"], "Expected the cached document to be encrypted")
+
+	// Changed behind the back of the cache
+	tc.UpdateId(m.ID, bson.M{"$set": bson.M{"name": "changed"}})
+	found := &cachedModel{ID: m.ID}
+	assert.Nil(t, Find(found))
+	assert.Equal(t, "cached", found.Name, "Expected the cached document")
+	assert.Nil(t, Find(found, SkipCache()))
+	assert.Equal(t, "changed", found.Name, "Expected the stored document")
+	found = &cachedModel{ID: m.ID}
+	assert.Nil(t, Find(found))
+	assert.Equal(t, "changed", found.Name, "Expected the document read with SkipCache to be cached")
+
+	t.Log("When the collection is re-encrypted")
+	_, err := Reencrypt(m, ReencryptOptions{From: m.DBConfig().CryptoConfig})
+	assert.Nil(t, err)
+	_, ok = testModelCache.Get(key)
+	assert.False(t, ok, "Expected the re-encrypted document to be invalidated")
+}
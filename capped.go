@@ -0,0 +1,287 @@
+package mgostore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultPollTimeout  = time.Second
+	defaultRetryBackoff = time.Second
+)
+
+// CappedCollection is the size of a capped collection, which keeps only the newest documents
+type CappedCollection struct {
+	// Maximum size of the collection in bytes, required
+	MaxBytes int
+	// Maximum number of documents, optional
+	MaxDocs int
+}
+
+/*
+CappedModel is a model whose collection is capped, eg. for notifications.
+Models of capped collections can be streamed with Subscribe.
+
+	func (n *Notification) CappedCollection() mgostore.CappedCollection {
+		return mgostore.CappedCollection{MaxBytes: 16 << 20, MaxDocs: 10000}
+	}
+*/
+type CappedModel interface {
+	Model
+	CappedCollection() CappedCollection
+}
+
+/*
+EnsureCapped creates the capped collection of the model unless it exists.
+An existing collection is left as it is, even if it is not capped.
+*/
+func EnsureCapped(m CappedModel) error {
+	cc := m.CappedCollection()
+	err := CreateCollection(m, CollectionOptions{Capped: &cc})
+	if err == ErrCollectionExists {
+		return nil
+	}
+	return err
+}
+
+// SubscribeOptions configures a Subscription
+type SubscribeOptions struct {
+	// ID of the last model which was delivered, to resume after it in the order of insertion.
+	// When nil, or when it is no longer in the capped collection, all of its documents are delivered first
+	ResumeAfter interface{}
+	// How long the cursor waits for new documents before it checks the context. Defaults to 1s
+	PollTimeout time.Duration
+	// Pause before the cursor is opened again after it died or failed with a transient error. Defaults to 1s
+	RetryBackoff time.Duration
+	// Number of models buffered in the channel
+	Buffer int
+}
+
+/*
+Subscription delivers the models which are inserted into a capped collection.
+C is closed when the context is cancelled or the subscription failed permanently, see Err.
+*/
+type Subscription[T any] struct {
+	C <-chan *T
+
+	mux    sync.Mutex
+	lastID interface{}
+	err    error
+}
+
+// Err returns the error which stopped the subscription, nil when it was cancelled
+func (s *Subscription[T]) Err() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.err
+}
+
+// LastID returns the ID of the last delivered model, to resume a new subscription after it
+func (s *Subscription[T]) LastID() interface{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.lastID
+}
+
+/*
+Subscribe tails the capped collection of the model T and delivers every document which
+matches the where clause, decoded and decrypted, in the order of insertion.
+The cursor is opened again after timeouts and transient errors, resuming after the last
+delivered model in the order of insertion, so documents which other processes inserted with
+smaller IDs are delivered as well. When the last delivered model has been evicted from the capped
+collection by then, all of its documents are delivered.
+
+	sub := mgostore.Subscribe[Notification](ctx, bson.M{"user_id": userID}, mgostore.SubscribeOptions{})
+	for n := range sub.C {
+		...
+	}
+	if err := sub.Err(); err != nil {
+		...
+	}
+*/
+func Subscribe[T any, P ModelPointer[T]](ctx context.Context, whereClause bson.M, opts SubscribeOptions) *Subscription[T] {
+	if opts.PollTimeout <= 0 {
+		opts.PollTimeout = defaultPollTimeout
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultRetryBackoff
+	}
+	ch := make(chan *T, opts.Buffer)
+	sub := &Subscription[T]{C: ch, lastID: opts.ResumeAfter}
+	go subscribe[T, P](ctx, sub, ch, whereClause, opts)
+	return sub
+}
+
+// subscribe tails until the context is cancelled or a permanent error occurs
+func subscribe[T any, P ModelPointer[T]](ctx context.Context, sub *Subscription[T], ch chan<- *T, whereClause bson.M, opts SubscribeOptions) {
+	defer close(ch)
+	err := retryTail(ctx, opts.RetryBackoff, func() error {
+		return tail[T, P](ctx, sub, ch, whereClause, opts)
+	})
+	sub.mux.Lock()
+	sub.err = err
+	sub.mux.Unlock()
+}
+
+/*
+retryTail runs tail until the context is cancelled or it fails with a permanent error, which is returned.
+tail is run again after transient errors, once the backoff passed.
+*/
+func retryTail(ctx context.Context, backoff time.Duration, tail func() error) error {
+	for ctx.Err() == nil {
+		err := tail()
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+		if !IsTransient(err) {
+			return wrapError(err)
+		}
+		if !sleepContext(ctx, backoff) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// tail delivers documents on a single session, it returns nil when the context is cancelled
+func tail[T any, P ModelPointer[T]](ctx context.Context, sub *Subscription[T], ch chan<- *T, whereClause bson.M, opts SubscribeOptions) error {
+	prototype := P(new(T))
+	config := prototype.DBConfig()
+	session, err := newSession(config)
+	if session != nil {
+		defer session.Close()
+	}
+	if err != nil {
+		return err
+	}
+	c := session.DB(config.DBName).C(prototype.CollectionName())
+	iter, skip, err := openTail(c, whereClause, sub.LastID(), opts.PollTimeout)
+	if err != nil {
+		return err
+	}
+	for {
+		m := P(new(T))
+		for iter.Next(m) {
+			if skip.skip(fetchModelIDVal(m)) {
+				// Delivered before the cursor was opened
+				m = P(new(T))
+				continue
+			}
+			bindAttachments(m, gridFSFiles{})
+			if err := decryptFields(m); err != nil {
+				iter.Close()
+				return err
+			}
+			select {
+			case ch <- (*T)(m):
+				sub.mux.Lock()
+				sub.lastID = fetchModelIDVal(m)
+				sub.mux.Unlock()
+			case <-ctx.Done():
+				iter.Close()
+				return nil
+			}
+			m = P(new(T))
+		}
+		if ctx.Err() != nil {
+			iter.Close()
+			return nil
+		}
+		if skip.lost() {
+			// The last delivered document was evicted while the cursor skipped to it.
+			// Eviction starts at the oldest document, so all documents left were inserted after it
+			if err := iter.Close(); err != nil {
+				return err
+			}
+			if iter, skip, err = openTail(c, whereClause, nil, opts.PollTimeout); err != nil {
+				return err
+			}
+			continue
+		}
+		if iter.Timeout() {
+			continue
+		}
+		// The cursor died, eg. as the collection was empty, or it failed
+		if err := iter.Close(); err != nil {
+			return err
+		}
+		if !sleepContext(ctx, opts.RetryBackoff) {
+			return nil
+		}
+		if iter, skip, err = openTail(c, whereClause, sub.LastID(), opts.PollTimeout); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+openTail opens a tailable cursor in the order of insertion, which resumes after the last delivered document.
+Capped collections have no index on their insertion order, so the cursor starts at the oldest
+document and the documents up to and including the last delivered one have to be skipped.
+Nothing is skipped when the last delivered document was evicted since, as all documents of the
+collection were inserted after it.
+*/
+func openTail(c *mgo.Collection, whereClause bson.M, lastID interface{}, timeout time.Duration) (*mgo.Iter, *resumeSkipper, error) {
+	if lastID != nil {
+		n, err := c.FindId(lastID).Count()
+		if err != nil {
+			return nil, nil, err
+		}
+		if n == 0 {
+			lastID = nil
+		}
+	}
+	return c.Find(tailQuery(whereClause, lastID)).Sort("$natural").Tail(timeout), &resumeSkipper{until: lastID}, nil
+}
+
+// resumeSkipper skips the documents of a tail up to and including the last delivered one
+type resumeSkipper struct {
+	// ID of the last delivered document, nil once it was read
+	until interface{}
+}
+
+// skip reports if the document with the ID was delivered before the cursor was opened
+func (s *resumeSkipper) skip(id interface{}) bool {
+	if s.until == nil {
+		return false
+	}
+	if idString(id) == idString(s.until) {
+		s.until = nil
+	}
+	return true
+}
+
+/*
+lost reports if the cursor read all documents without the last delivered one,
+which happens when it was evicted after the cursor was opened.
+*/
+func (s *resumeSkipper) lost() bool {
+	return s.until != nil
+}
+
+/*
+tailQuery returns the where clause, extended to the last delivered document so that
+it is read even when it no longer matches.
+*/
+func tailQuery(whereClause bson.M, lastID interface{}) bson.M {
+	if lastID == nil || len(whereClause) == 0 {
+		return whereClause
+	}
+	return bson.M{"$or": []bson.M{whereClause, {"_id": lastID}}}
+}
+
+// sleepContext pauses for d, it returns false when the context is cancelled before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,118 @@
+package mgostore
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type notificationModel struct {
+	ID      bson.ObjectId `bson:"_id,omitempty"`
+	UserID  string        `bson:"user_id"`
+	Message string        `bson:"message" encrypt:"aes"`
+}
+
+func (m *notificationModel) CollectionName() string { return "notifications" }
+
+func (m *notificationModel) DBConfig() *MongoConfig { return testMongoConfig() }
+
+func (m *notificationModel) CappedCollection() CappedCollection {
+	return CappedCollection{MaxBytes: 1 << 20, MaxDocs: 100}
+}
+
+func Test_tailQuery(t *testing.T) {
+	assert.Equal(t, bson.M{"user_id": "u1"}, tailQuery(bson.M{"user_id": "u1"}, nil))
+
+	id := bson.NewObjectId()
+	assert.Nil(t, tailQuery(nil, id), "Expected all documents to be read to skip up to the last ID")
+	assert.Equal(t,
+		bson.M{"$or": []bson.M{{"user_id": "u1"}, {"_id": id}}},
+		tailQuery(bson.M{"user_id": "u1"}, id),
+		"Expected the last delivered document to be read")
+}
+
+func Test_resumeSkipper(t *testing.T) {
+	first, last, next := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	s := &resumeSkipper{until: last}
+	assert.True(t, s.skip(first), "Expected documents before the last delivered one to be skipped")
+	assert.True(t, s.lost())
+	assert.True(t, s.skip(last), "Expected the last delivered document to be skipped")
+	assert.False(t, s.lost())
+	assert.False(t, s.skip(next), "Expected documents after the last delivered one to be delivered")
+
+	t.Log("When the last delivered document was evicted during the scan")
+	evicted := bson.NewObjectId()
+	s = &resumeSkipper{until: evicted}
+	assert.True(t, s.skip(first))
+	assert.True(t, s.skip(next))
+	assert.True(t, s.lost(), "Expected the cursor to be reopened from the start")
+
+	t.Log("When nothing needs to be skipped")
+	s = &resumeSkipper{}
+	assert.False(t, s.skip(first))
+	assert.False(t, s.lost())
+}
+
+func Test_sleepContext(t *testing.T) {
+	assert.True(t, sleepContext(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, sleepContext(ctx, time.Minute), "Expected the sleep to end with the context")
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	setTestEnvVars()
+	os.Setenv("MONGODB_SERVERS", "subscribe_invalid_server")
+	defer setTestEnvVars()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	sub := Subscribe[notificationModel](ctx, nil, SubscribeOptions{RetryBackoff: 10 * time.Millisecond})
+	_, open := <-sub.C
+	assert.False(t, open, "Expected the channel to be closed when the context is done")
+	assert.Nil(t, sub.Err(), "Expected transient errors to be retried until the context is done")
+}
+
+func TestSubscribe(t *testing.T) {
+	setTestEnvVars()
+	t.Log("When connection can be established")
+	m := &notificationModel{}
+	// Make sure to drop the entire collection after the test is run
+	defer DropCollection(m)
+	assert.Nil(t, EnsureCapped(m))
+	assert.Nil(t, EnsureCapped(m), "Expected an existing collection to be kept")
+
+	first := &notificationModel{UserID: "u1", Message: "first"}
+	Create(first)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub := Subscribe[notificationModel](ctx, bson.M{"user_id": "u1"}, SubscribeOptions{PollTimeout: 100 * time.Millisecond})
+
+	n := <-sub.C
+	assert.Equal(t, "first", n.Message, "Expected existing documents to be delivered decrypted")
+	Create(&notificationModel{UserID: "u2", Message: "other"})
+	Create(&notificationModel{UserID: "u1", Message: "second"})
+	n = <-sub.C
+	assert.Equal(t, "second", n.Message, "Expected new matching documents to be delivered")
+	assert.Equal(t, n.ID, sub.LastID())
+
+	t.Log("When a document with a smaller ID is inserted after the last delivered one")
+	cancel()
+	earlierID := bson.NewObjectIdWithTime(time.Now().Add(-time.Hour))
+	session, _ := newSession(m.DBConfig())
+	defer session.Close()
+	fetchCollection(m, session).Insert(bson.M{"_id": earlierID, "user_id": "u1"})
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub = Subscribe[notificationModel](ctx, bson.M{"user_id": "u1"}, SubscribeOptions{
+		ResumeAfter: n.ID,
+		PollTimeout: 100 * time.Millisecond,
+	})
+	n = <-sub.C
+	assert.Equal(t, earlierID, n.ID, "Expected to resume after the last delivered document in the order of insertion")
+}
@@ -0,0 +1,157 @@
+package mgostore
+
+import (
+	"strings"
+
+	"github.com/gsingharoy/mgostore/lib"
+)
+
+/*
+Cipher encrypts and decrypts the values of encrypted fields.
+Ciphers are selected per field by the value of the encrypt tag, eg. `encrypt:"chacha20"`.
+additionalData binds the ciphertext to the document and field it is stored in.
+Authenticated ciphers must fail to decrypt when it does not match and return
+lib.ErrAuthenticationFailed in that case.
+*/
+type Cipher interface {
+	Encrypt(key []byte, text string, additionalData []byte) (string, error)
+	Decrypt(key []byte, cryptoText string, additionalData []byte) (string, error)
+}
+
+/*
+RegisterCipher makes a cipher available for the encrypt tag under the given name.
+Registering a cipher under an existing name replaces it.
+*/
+func RegisterCipher(name string, c Cipher) {
+	cipherMux.Lock()
+	defer cipherMux.Unlock()
+	cipherRegistry[name] = c
+}
+
+/*
+unauthenticatedCipher is implemented by ciphers which may decrypt values without authenticating them,
+so that a wrong key or a moved value yields garbage instead of an error.
+*/
+type unauthenticatedCipher interface {
+	authenticated() bool
+}
+
+// isAuthenticated reports if the cipher authenticates every value it decrypts
+func isAuthenticated(c Cipher) bool {
+	uc, ok := c.(unauthenticatedCipher)
+	return !ok || uc.authenticated()
+}
+
+/*
+resolveCipher returns the cipher registered under the name, as configured by the crypto config.
+The fallback of "aes" to AES-CFB is only enabled by CryptoConfig.LegacyCFB.
+*/
+func resolveCipher(name string, cryptoConfig *CryptoConfig) (Cipher, error) {
+	c, err := lookupCipher(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.(aesCipher); ok && cryptoConfig != nil && cryptoConfig.LegacyCFB {
+		return aesCipher{legacyCFB: true}, nil
+	}
+	return c, nil
+}
+
+// lookupCipher returns the cipher registered under the name
+func lookupCipher(name string) (Cipher, error) {
+	cipherMux.RLock()
+	defer cipherMux.RUnlock()
+	c, ok := cipherRegistry[name]
+	if !ok {
+		return nil, ErrUnknownCipher
+	}
+	return c, nil
+}
+
+/*
+parseEncryptTag splits the value of an encrypt tag into the name of the cipher and
+the name of the key, eg. `encrypt:"chacha20,key=pii"` => ("chacha20", "pii").
+The key name is empty when the default key should be used.
+*/
+func parseEncryptTag(tag string) (cipherName string, keyName string) {
+	parts := strings.Split(tag, ",")
+	for _, option := range parts[1:] {
+		if strings.HasPrefix(option, "key=") {
+			keyName = strings.TrimPrefix(option, "key=")
+		}
+	}
+	return parts[0], keyName
+}
+
+/*
+aesCipher is the default cipher for the tag "aes" and for Secret fields.
+It encrypts with AES-GCM and marks its ciphertexts with aesGCMPrefix. Values without the
+prefix were stored with AES-CFB by older versions. They are not authenticated, so they are
+only decrypted with aesCFBCipher when legacyCFB is set by CryptoConfig.LegacyCFB, and fail
+with ErrUnauthenticatedCiphertext otherwise.
+*/
+type aesCipher struct {
+	legacyCFB bool
+}
+
+// aesGCMPrefix marks AES-GCM ciphertexts of aesCipher, it is not part of the base64 alphabet
+const aesGCMPrefix = "gcm:"
+
+func (aesCipher) Encrypt(key []byte, text string, additionalData []byte) (string, error) {
+	cryptoText, err := aesGCMCipher{}.Encrypt(key, text, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return aesGCMPrefix + cryptoText, nil
+}
+
+func (c aesCipher) Decrypt(key []byte, cryptoText string, additionalData []byte) (string, error) {
+	if strings.HasPrefix(cryptoText, aesGCMPrefix) {
+		return aesGCMCipher{}.Decrypt(key, strings.TrimPrefix(cryptoText, aesGCMPrefix), additionalData)
+	}
+	if !c.legacyCFB {
+		return "", ErrUnauthenticatedCiphertext
+	}
+	return aesCFBCipher{}.Decrypt(key, cryptoText, additionalData)
+}
+
+func (c aesCipher) authenticated() bool { return !c.legacyCFB }
+
+// aesGCMCipher is used for the tag "aes-gcm"
+type aesGCMCipher struct{}
+
+func (aesGCMCipher) Encrypt(key []byte, text string, additionalData []byte) (string, error) {
+	return lib.AesGcmEncrypt(key, text, additionalData)
+}
+
+func (aesGCMCipher) Decrypt(key []byte, cryptoText string, additionalData []byte) (string, error) {
+	return lib.AesGcmDecrypt(key, cryptoText, additionalData)
+}
+
+// chaCha20Poly1305Cipher is used for the tags "chacha20" and "chacha20-poly1305"
+type chaCha20Poly1305Cipher struct{}
+
+func (chaCha20Poly1305Cipher) Encrypt(key []byte, text string, additionalData []byte) (string, error) {
+	return lib.ChaCha20Poly1305Encrypt(key, text, additionalData)
+}
+
+func (chaCha20Poly1305Cipher) Decrypt(key []byte, cryptoText string, additionalData []byte) (string, error) {
+	return lib.ChaCha20Poly1305Decrypt(key, cryptoText, additionalData)
+}
+
+/*
+aesCFBCipher is the legacy cipher for the tag "aes-cfb".
+It reads values which were stored before fields were authenticated.
+It does not authenticate its ciphertext, so it ignores additionalData.
+*/
+type aesCFBCipher struct{}
+
+func (aesCFBCipher) Encrypt(key []byte, text string, _ []byte) (string, error) {
+	return lib.AesEncrypt(key, text)
+}
+
+func (aesCFBCipher) Decrypt(key []byte, cryptoText string, _ []byte) (string, error) {
+	return lib.AesDecrypt(key, cryptoText)
+}
+
+func (aesCFBCipher) authenticated() bool { return false }
@@ -0,0 +1,104 @@
+package mgostore
+
+import (
+	"testing"
+
+	"github.com/gsingharoy/mgostore/lib"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type cipherModel struct {
+	mockModel
+	GCMField    string `bson:"gcm_field" encrypt:"aes-gcm"`
+	ChaChaField string `bson:"chacha_field" encrypt:"chacha20,key=pii"`
+	LegacyField string `bson:"legacy_field" encrypt:"aes-cfb"`
+	MissingKey  string `bson:"missing_key" encrypt:"aes,key=unknown"`
+}
+
+func (m *cipherModel) DBConfig() *MongoConfig {
+	config := testMongoConfig()
+	config.CryptoConfig.Keys = map[string][]byte{"pii": []byte(testPIIEncryptionSecret)}
+	return config
+}
+
+const testPIIEncryptionSecret string = "D0A1C3C2E8F3B0A9F2E5D4C7B6A9E8F1"
+
+type reverseCipher struct{}
+
+func (reverseCipher) Encrypt(_ []byte, text string, _ []byte) (string, error) {
+	r := []rune(text)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r), nil
+}
+
+func (c reverseCipher) Decrypt(key []byte, cryptoText string, additionalData []byte) (string, error) {
+	return c.Encrypt(key, cryptoText, additionalData)
+}
+
+func Test_parseEncryptTag(t *testing.T) {
+	c, k := parseEncryptTag("aes")
+	assert.Equal(t, "aes", c)
+	assert.Equal(t, "", k, "Expected the default key")
+
+	c, k = parseEncryptTag("chacha20,key=pii")
+	assert.Equal(t, "chacha20", c)
+	assert.Equal(t, "pii", k)
+}
+
+func TestCiphers(t *testing.T) {
+	m := &cipherModel{
+		GCMField:    "gcm text",
+		ChaChaField: "chacha text",
+		LegacyField: "legacy text"}
+	m.ID = bson.NewObjectId()
+	err := encryptFields(m)
+	assert.Nil(t, err, "Expected no error")
+	assert.NotEqual(t, "gcm text", m.GCMField, "Expected gcm field to be encrypted")
+	assert.NotEqual(t, "chacha text", m.ChaChaField, "Expected chacha field to be encrypted")
+	assert.NotEqual(t, "legacy text", m.LegacyField, "Expected legacy field to be encrypted")
+
+	t.Log("When a field names a key")
+	decryptedText, err := lib.ChaCha20Poly1305Decrypt(
+		[]byte(testPIIEncryptionSecret),
+		m.ChaChaField,
+		[]byte(m.ID.Hex()+"\x00chacha_field"))
+	assert.Nil(t, err, "Expected the named key to be used")
+	assert.Equal(t, "chacha text", decryptedText)
+
+	t.Log("When the legacy cipher is used")
+	decryptedText, _ = lib.AesDecrypt([]byte(testEncryptionSecret), m.LegacyField)
+	assert.Equal(t, "legacy text", decryptedText)
+
+	err = decryptFields(m)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, "gcm text", m.GCMField)
+	assert.Equal(t, "chacha text", m.ChaChaField)
+	assert.Equal(t, "legacy text", m.LegacyField)
+
+	t.Log("When the named key is not configured")
+	m.MissingKey = "some text"
+	err = encryptFields(m)
+	assert.Equal(t, ErrMissingCryptoSecret, err, "Expected missing crypto secret error")
+	assert.Equal(t, "gcm text", m.GCMField, "Expected no field to be encrypted")
+}
+
+func TestRegisterCipher(t *testing.T) {
+	type customModel struct {
+		mockModel
+		Field string `bson:"field" encrypt:"reverse"`
+	}
+	m := &customModel{Field: "abc"}
+	assert.Equal(t, ErrUnknownCipher, encryptFields(m), "Expected unknown cipher error")
+
+	RegisterCipher("reverse", reverseCipher{})
+	defer func() {
+		cipherMux.Lock()
+		delete(cipherRegistry, "reverse")
+		cipherMux.Unlock()
+	}()
+	assert.Nil(t, encryptFields(m))
+	assert.Equal(t, "cba", m.Field, "Expected the registered cipher to be used")
+}
@@ -0,0 +1,167 @@
+/*
+mgostore-reencrypt rewrites the encrypted fields of every document in a collection,
+eg. after rotating keys or changing ciphers.
+With -mode encrypt it encrypts fields which are stored in plaintext, with -mode decrypt it
+stores the fields in plaintext again.
+
+	MGOSTORE_FROM_SECRET=... MGOSTORE_TO_SECRET=... mgostore-reencrypt \
+		-servers localhost -db test -collection my_awesome_models \
+		-fields an_encrypted_field:aes,ssn:chacha20:pii \
+		-checkpoint my_awesome_models.checkpoint
+
+Keys and the password are read from the environment, so that they do not end up in the shell history.
+MGOSTORE_FROM_SECRET and MGOSTORE_TO_SECRET hold the default keys, named keys are read from
+MGOSTORE_FROM_KEY_<NAME> and MGOSTORE_TO_KEY_<NAME>, eg. MGOSTORE_FROM_KEY_PII.
+When no MGOSTORE_TO_* keys are set the documents are re-encrypted with the old keys,
+which is useful to change ciphers only. -mode encrypt only needs the MGOSTORE_TO_* keys.
+
+Decrypting with aes-cfb is refused unless -allow-unauthenticated is set, since a wrong key
+yields garbage instead of an error. Run with -dry-run first in that case.
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gsingharoy/mgostore"
+)
+
+func main() {
+	servers := flag.String("servers", "localhost", "comma separated mongo servers")
+	dbName := flag.String("db", "", "name of the database")
+	collection := flag.String("collection", "", "name of the collection")
+	fields := flag.String("fields", "", "comma separated encrypted fields as name:cipher[:key]")
+	mode := flag.String("mode", "reencrypt", "reencrypt, encrypt plaintext fields or decrypt them to plaintext")
+	fromCipher := flag.String("from-cipher", "", "cipher the documents are currently encrypted with, eg. aes-cfb")
+	allowUnauthenticated := flag.Bool("allow-unauthenticated", false, "allow decrypting with ciphers which cannot detect a wrong key, eg. aes-cfb")
+	batchSize := flag.Int("batch", 100, "number of documents per batch")
+	throttle := flag.Duration("throttle", 0, "pause after every batch")
+	dryRun := flag.Bool("dry-run", false, "only report documents which cannot be decrypted")
+	checkpoint := flag.String("checkpoint", "", "file to store the progress in, to resume an interrupted run")
+	ssl := flag.Bool("ssl", false, "connect with TLS")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout to connect to mongo")
+	username := flag.String("username", "", "user to authenticate as, the password is read from MGOSTORE_PASSWORD")
+	authSource := flag.String("auth-source", "", "database the user is defined in")
+	authMechanism := flag.String("auth-mechanism", "", "authentication mechanism, eg. SCRAM-SHA-1")
+	flag.Parse()
+
+	if *dbName == "" || *collection == "" || *fields == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	specs, err := parseFields(*fields)
+	if err != nil {
+		exit(err)
+	}
+
+	opts := mgostore.ReencryptOptions{
+		From:                 cryptoConfigFromEnv("MGOSTORE_FROM_SECRET", "MGOSTORE_FROM_KEY_", specs),
+		To:                   cryptoConfigFromEnv("MGOSTORE_TO_SECRET", "MGOSTORE_TO_KEY_", specs),
+		FromCipher:           *fromCipher,
+		AllowUnauthenticated: *allowUnauthenticated,
+		BatchSize:            *batchSize,
+		Throttle:             *throttle,
+		DryRun:               *dryRun,
+	}
+	switch *mode {
+	case "reencrypt", "decrypt":
+		if *mode == "decrypt" {
+			opts.Mode = mgostore.DecryptToPlaintext
+		}
+		if opts.From == nil {
+			exit(errors.New("no keys set in MGOSTORE_FROM_SECRET or MGOSTORE_FROM_KEY_<NAME>"))
+		}
+	case "encrypt":
+		opts.Mode = mgostore.EncryptPlaintext
+		if opts.To == nil {
+			exit(errors.New("no keys set in MGOSTORE_TO_SECRET or MGOSTORE_TO_KEY_<NAME>"))
+		}
+	default:
+		exit(fmt.Errorf("invalid mode %q, expected reencrypt, encrypt or decrypt", *mode))
+	}
+	if *checkpoint != "" {
+		opts.Checkpoint = &mgostore.FileCheckpoint{Path: *checkpoint}
+	}
+	config := &mgostore.MongoConfig{
+		Servers:       *servers,
+		DBName:        *dbName,
+		Timeout:       *timeout,
+		IsSSL:         *ssl,
+		Username:      *username,
+		AuthSource:    *authSource,
+		AuthMechanism: *authMechanism,
+	}
+	if *username != "" {
+		config.CredentialProvider = &mgostore.EnvCredentials{PasswordVar: "MGOSTORE_PASSWORD"}
+	}
+
+	report, err := mgostore.ReencryptCollection(config, *collection, specs, opts)
+	if report != nil {
+		fmt.Printf("processed: %d\n", report.Processed)
+		fmt.Printf("rewritten: %d\n", report.Reencrypted)
+		fmt.Printf("undecryptable: %d\n", len(report.Undecryptable))
+		for _, id := range report.Undecryptable {
+			fmt.Printf("  %v\n", id)
+		}
+		fmt.Printf("conflicts: %d\n", len(report.Conflicts))
+		for _, id := range report.Conflicts {
+			fmt.Printf("  %v\n", id)
+		}
+		fmt.Printf("last id: %v\n", report.LastID)
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+// parseFields parses name:cipher[:key] specs
+func parseFields(fields string) ([]mgostore.EncryptedFieldSpec, error) {
+	var specs []mgostore.EncryptedFieldSpec
+	for _, field := range strings.Split(fields, ",") {
+		parts := strings.Split(field, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid field %q, expected name:cipher[:key]", field)
+		}
+		spec := mgostore.EncryptedFieldSpec{Name: parts[0], Cipher: parts[1]}
+		if len(parts) == 3 {
+			spec.Key = parts[2]
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// cryptoConfigFromEnv reads the default and the named keys of the specs from the environment
+func cryptoConfigFromEnv(secretVar string, keyPrefix string, specs []mgostore.EncryptedFieldSpec) *mgostore.CryptoConfig {
+	var config *mgostore.CryptoConfig
+	if secret := os.Getenv(secretVar); secret != "" {
+		config = &mgostore.CryptoConfig{AESSecret: []byte(secret)}
+	}
+	for _, spec := range specs {
+		if spec.Key == "" {
+			continue
+		}
+		key := os.Getenv(keyPrefix + strings.ToUpper(spec.Key))
+		if key == "" {
+			continue
+		}
+		if config == nil {
+			config = &mgostore.CryptoConfig{}
+		}
+		if config.Keys == nil {
+			config.Keys = map[string][]byte{}
+		}
+		config.Keys[spec.Key] = []byte(key)
+	}
+	return config
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, "mgostore-reencrypt:", err)
+	os.Exit(1)
+}
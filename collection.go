@@ -0,0 +1,151 @@
+package mgostore
+
+import (
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// NamespaceNotFound, returned when modifying a collection which does not exist
+	namespaceNotFoundCode = 26
+	// NamespaceExists, returned when creating a collection which exists
+	namespaceExistsCode = 48
+)
+
+/*
+CollectionOptions are the options of a collection created by CreateCollection.
+Collections which are created implicitly by the first write have none of them.
+*/
+type CollectionOptions struct {
+	// Makes the collection capped, see CappedModel
+	Capped *CappedCollection
+	// Default collation of the queries and indexes of the collection, eg. &mgo.Collation{Locale: "en", Strength: 2}
+	Collation *mgo.Collation
+	// Validator of the documents, eg. bson.M{"$jsonSchema": mgostore.JSONSchema(m)}
+	Validator bson.M
+	// Which documents the validator checks. Defaults to ValidationStrict
+	ValidationLevel ValidationLevel
+}
+
+/*
+CreateCollection creates the collection of the model with the options.
+It returns ErrCollectionExists when the collection exists.
+
+	err := mgostore.CreateCollection(&User{}, mgostore.CollectionOptions{
+		Collation: &mgo.Collation{Locale: "en", Strength: 2},
+	})
+*/
+func CreateCollection(m Model, options CollectionOptions, opts ...Option) error {
+	op := newModelOperation("create_collection", m, nil, opts)
+	op.idempotent = true
+	return op.run(func(c *mgo.Collection) error {
+		err := createCollection(c, options)
+		if err == ErrCollectionExists && op.retried() {
+			// Created by the attempt which failed with a transient error
+			return nil
+		}
+		return err
+	})
+}
+
+// createCollection runs the create command of the collection
+func createCollection(c *mgo.Collection, options CollectionOptions) error {
+	err := c.Database.Run(createCommand(c.Name, options), nil)
+	if hasErrorCode(err, namespaceExistsCode) {
+		return ErrCollectionExists
+	}
+	return err
+}
+
+// createCommand returns the command which creates the named collection with the options
+func createCommand(name string, options CollectionOptions) bson.D {
+	cmd := bson.D{{Name: "create", Value: name}}
+	if options.Capped != nil {
+		cmd = append(cmd, bson.DocElem{Name: "capped", Value: true}, bson.DocElem{Name: "size", Value: options.Capped.MaxBytes})
+		if options.Capped.MaxDocs > 0 {
+			cmd = append(cmd, bson.DocElem{Name: "max", Value: options.Capped.MaxDocs})
+		}
+	}
+	if options.Collation != nil {
+		cmd = append(cmd, bson.DocElem{Name: "collation", Value: options.Collation})
+	}
+	if options.Validator != nil {
+		cmd = append(cmd, bson.DocElem{Name: "validator", Value: options.Validator})
+	}
+	if options.ValidationLevel != "" {
+		cmd = append(cmd, bson.DocElem{Name: "validationLevel", Value: string(options.ValidationLevel)})
+	}
+	return cmd
+}
+
+// CollectionExists reports if the collection of the model exists
+func CollectionExists(m Model, opts ...Option) (bool, error) {
+	var exists bool
+	op := newModelOperation("collection_exists", m, nil, opts)
+	op.idempotent = true
+	err := op.run(func(c *mgo.Collection) error {
+		names, err := c.Database.CollectionNames()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			exists = exists || name == c.Name
+		}
+		return nil
+	})
+	return exists, err
+}
+
+/*
+RenameCollection renames the collection of the model to newName, within its DB.
+It returns ErrCollectionNotFound when the collection does not exist and ErrCollectionExists
+when a collection with the new name exists. Cached documents of the model are not invalidated.
+*/
+func RenameCollection(m Model, newName string, opts ...Option) error {
+	op := newModelOperation("rename_collection", m, nil, opts)
+	return op.run(func(c *mgo.Collection) error {
+		cmd := bson.D{
+			{Name: "renameCollection", Value: c.FullName},
+			{Name: "to", Value: c.Database.Name + "." + newName},
+		}
+		err := c.Database.Session.DB("admin").Run(cmd, nil)
+		switch {
+		case hasErrorCode(err, namespaceNotFoundCode):
+			return ErrCollectionNotFound
+		case hasErrorCode(err, namespaceExistsCode):
+			return ErrCollectionExists
+		}
+		return err
+	})
+}
+
+/*
+DropCollection drops the collection of the model along with its documents and indexes.
+Dropping a collection which does not exist is no error. Cached documents of the model are not invalidated.
+*/
+func DropCollection(m Model, opts ...Option) error {
+	op := newModelOperation("drop_collection", m, nil, opts)
+	op.idempotent = true
+	return op.run(func(c *mgo.Collection) error {
+		err := c.DropCollection()
+		if hasErrorCode(err, namespaceNotFoundCode) || (err != nil && err.Error() == "ns not found") {
+			// Older servers report a missing collection without a code
+			return nil
+		}
+		return err
+	})
+}
+
+// ListCollections returns the names of the collections in the DB of the config
+func ListCollections(config *MongoConfig, opts ...Option) ([]string, error) {
+	var names []string
+	op := newOperation(config, "", opts)
+	op.name = "list_collections"
+	op.idempotent = true
+	err := op.run(func(c *mgo.Collection) error {
+		var err error
+		names, err = c.Database.CollectionNames()
+		return err
+	})
+	return names, err
+}
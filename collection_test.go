@@ -0,0 +1,90 @@
+package mgostore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type renamedModel struct {
+	ID bson.ObjectId `bson:"_id,omitempty"`
+}
+
+func (m *renamedModel) CollectionName() string { return "renamed_models" }
+
+func (m *renamedModel) DBConfig() *MongoConfig { return testMongoConfig() }
+
+func Test_createCommand(t *testing.T) {
+	t.Log("When no options are given")
+	assert.Equal(t, bson.D{{Name: "create", Value: "users"}}, createCommand("users", CollectionOptions{}))
+
+	t.Log("When all options are given")
+	collation := &mgo.Collation{Locale: "en", Strength: 2}
+	validator := bson.M{"$jsonSchema": bson.M{"bsonType": "object"}}
+	cmd := createCommand("users", CollectionOptions{
+		Capped:          &CappedCollection{MaxBytes: 4096, MaxDocs: 10},
+		Collation:       collation,
+		Validator:       validator,
+		ValidationLevel: ValidationModerate,
+	})
+	assert.Equal(t, bson.D{
+		{Name: "create", Value: "users"},
+		{Name: "capped", Value: true},
+		{Name: "size", Value: 4096},
+		{Name: "max", Value: 10},
+		{Name: "collation", Value: collation},
+		{Name: "validator", Value: validator},
+		{Name: "validationLevel", Value: "moderate"},
+	}, cmd)
+
+	t.Log("When the capped collection has no maximum number of documents")
+	cmd = createCommand("users", CollectionOptions{Capped: &CappedCollection{MaxBytes: 4096}})
+	assert.Equal(t, bson.D{
+		{Name: "create", Value: "users"},
+		{Name: "capped", Value: true},
+		{Name: "size", Value: 4096},
+	}, cmd)
+}
+
+func TestCollectionLifecycle(t *testing.T) {
+	m := &mockModel{}
+	t.Log("When no connection can be established")
+	setTestEnvVars()
+	os.Setenv("MONGODB_SERVERS", "invalid_server")
+	err := CreateCollection(m, CollectionOptions{})
+	assert.Equal(t, "no reachable servers", err.Error(), "Expected not reachable servers error")
+
+	setTestEnvVars()
+	t.Log("When connection can be established")
+	defer DropCollection(m)
+	assert.Nil(t, DropCollection(m), "Expected no error for a missing collection")
+	exists, err := CollectionExists(m)
+	assert.Nil(t, err, "Expected no error")
+	assert.False(t, exists)
+
+	assert.Nil(t, CreateCollection(m, CollectionOptions{Collation: &mgo.Collation{Locale: "en", Strength: 2}}))
+	exists, _ = CollectionExists(m)
+	assert.True(t, exists, "Expected the collection to be created")
+	assert.Equal(t, ErrCollectionExists, CreateCollection(m, CollectionOptions{}))
+	names, err := ListCollections(m.DBConfig())
+	assert.Nil(t, err, "Expected no error")
+	assert.Contains(t, names, m.CollectionName())
+
+	t.Log("When the collection is renamed")
+	renamed := &renamedModel{}
+	defer DropCollection(renamed)
+	assert.Nil(t, RenameCollection(m, renamed.CollectionName()))
+	exists, _ = CollectionExists(m)
+	assert.False(t, exists, "Expected the old name to be gone")
+	exists, _ = CollectionExists(renamed)
+	assert.True(t, exists, "Expected the new name to exist")
+	assert.Equal(t, ErrCollectionNotFound, RenameCollection(m, renamed.CollectionName()))
+
+	t.Log("When the collection is dropped")
+	assert.Nil(t, DropCollection(renamed))
+	exists, _ = CollectionExists(renamed)
+	assert.False(t, exists, "Expected the collection to be dropped")
+}
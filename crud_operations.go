@@ -1,116 +1,217 @@
 package mgostore
 
-import "gopkg.in/mgo.v2/bson"
+import (
+	"reflect"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
 
 /*
 Add all storage related methods here.
 Contains basic methods for CRUD operations on models.
+Every method accepts Options which override the settings of the MongoConfig for this call only.
 */
 
 /*
 Stores the struct to DB
 This will update this model with all its attributes in the DB
+New attachments are uploaded to GridFS, the files of replaced attachments are removed.
+The model is left as it is when the update fails, so that it can be retried.
 */
-func Update(m Model) error {
+func Update(m Model, opts ...Option) error {
 	id := fetchModelIDVal(m)
-	session, err := newSession(m.DBConfig())
-	if session != nil {
-		defer session.Close()
+	// Unknown ciphers and missing keys fail before connecting
+	if _, err := fetchEncryptedFields(m, m.DBConfig().CryptoConfig); err != nil {
+		return err
 	}
+	op := newModelOperation("update", m, bson.M{"_id": id}, opts)
+	files := gridFSFiles{op: op}
+	// values need to be hashed and encrypted, on a copy so that the model is left as it is on errors
+	doc := copyModel(m)
+	uploaded, err := uploadAttachments(doc, files)
 	if err != nil {
 		return err
 	}
-	c := fetchCollection(m, session)
-	if c == nil {
-		return ErrMongoCollectionNotFetched
-	}
-	// values need to be encrypted
-	encryptFields(m)
-
-	c.UpdateId(id, bson.M{"$set": m})
+	// Setting the same values again has the same effect
+	op.idempotent = true
+	var replaced []*Attachment
+	err = op.run(func(c *mgo.Collection) error {
+		var stored Model
+		if hasAttachments(m) || hasHashedFields(m) {
+			// Hashed fields are only hashed again when they differ from the stored hash
+			stored = newModelOf(m)
+			if err := c.FindId(id).One(stored); err != nil {
+				return err
+			}
+		}
+		changed := copyModel(doc)
+		if err := hashFields(changed, stored); err != nil {
+			return err
+		}
+		if err := encryptFields(changed); err != nil {
+			return err
+		}
+		if hasAttachments(m) {
+			// The files of replaced attachments are removed once the update succeeded
+			bindAttachments(stored, files)
+			replaced = replacedAttachments(stored, m)
+		}
+		if err := c.UpdateId(id, bson.M{"$set": changed}); err != nil {
+			return err
+		}
+		op.documents = 1
+		return nil
+	})
+	// Invalidated even when the update failed, it might have been applied nonetheless
+	invalidateCache(m, id)
 	if err != nil {
+		rollbackAttachments(uploaded)
+		return err
+	}
+	if err = removeAttachments(replaced); err != nil {
 		return err
 	}
-	// Fetch the saved value from storage
-	Find(m)
-	return nil
+	// Fetch the saved value from storage, from the primary as a secondary might not have it yet
+	return Find(m, append(opts[:len(opts):len(opts)], WithReadPreference(mgo.Primary))...)
 }
 
 /*
 Returns the Struct from the DB
 For this to work, the model should be initialized with the correct value of Id for which to lookup in DB
+Models which implement CachedModel are read from their cache first, see SkipCache.
 */
-func Find(m Model) error {
+func Find(m Model, opts ...Option) error {
 	id := fetchModelIDVal(m)
 	// if !bson.IsObjectIdHex(id) {
 	// 	return errors.New("invalid id")
 	// }
-	session, err := newSession(m.DBConfig())
-	if session != nil {
-		defer session.Close()
+	op := newModelOperation("find", m, bson.M{"_id": id}, opts)
+	cache, key := modelCache(m), cacheKey(m, id)
+	if cache != nil && !op.skipCache {
+		if data, ok := cache.Get(key); ok {
+			if loadCached(m, data) == nil {
+				bindAttachments(m, gridFSFiles{})
+				if decryptFields(m) == nil {
+					return op.preloadFromMongo([]reflect.Value{reflect.ValueOf(m)})
+				}
+			}
+			// The entry is unusable, eg. encrypted with a rotated key, the document is read again
+			cache.Delete(key)
+			resetModel(m, id)
+		}
 	}
+	op.idempotent = true
+	err := op.run(func(c *mgo.Collection) error {
+		if cache == nil {
+			if err := c.FindId(id).One(m); err != nil {
+				return err
+			}
+		} else {
+			// The document is cached as stored, with its fields encrypted
+			var raw bson.Raw
+			if err := c.FindId(id).One(&raw); err != nil {
+				return err
+			}
+			if err := raw.Unmarshal(m); err != nil {
+				return err
+			}
+			cache.Set(key, raw.Data)
+		}
+		op.documents = 1
+		bindAttachments(m, gridFSFiles{})
+		return decryptFields(m)
+	})
 	if err != nil {
 		return err
 	}
-	c := fetchCollection(m, session)
-	if c == nil {
-		return ErrMongoCollectionNotFetched
-	}
-	if err = c.FindId(id).One(m); err != nil {
-		return err
-	}
-	decryptFields(m)
-	return nil
+	return op.preloadFromMongo([]reflect.Value{reflect.ValueOf(m)})
 }
 
 /*
 Delete from the DB
 For this to work, the model should be initialized with the correct value of Id for which to lookup in DB
+The delete rules declared in the ref tags of the model are applied first, see DeleteRule.
 */
-func Destroy(m Model) error {
+func Destroy(m Model, opts ...Option) error {
 	id := fetchModelIDVal(m)
-	session, err := newSession(m.DBConfig())
-	if session != nil {
-		defer session.Close()
-	}
-	if err != nil {
+	op := newModelOperation("destroy", m, bson.M{"_id": id}, opts)
+	if err := enforceDeleteRules(m, mongoDependents{op: op, opts: opts}); err != nil {
 		return err
 	}
-	c := fetchCollection(m, session)
-	if c == nil {
-		return ErrMongoCollectionNotFetched
-	}
-	if err := c.Remove(bson.M{"_id": id}); err != nil {
+	op.idempotent = true
+	var attachments []*Attachment
+	err := op.run(func(c *mgo.Collection) error {
+		if hasAttachments(m) {
+			// The files of the attachments are removed along with the model
+			stored := newModelOf(m)
+			if err := c.FindId(id).One(stored); err == nil {
+				bindAttachments(stored, gridFSFiles{op: op})
+				attachments = storedAttachments(stored)
+			}
+		}
+		err := c.Remove(bson.M{"_id": id})
+		if err == mgo.ErrNotFound && op.retried() {
+			// Removed by the attempt which failed with a transient error
+			err = nil
+		}
+		if err == nil {
+			op.documents = 1
+		}
+		return err
+	})
+	invalidateCache(m, id)
+	if err != nil {
 		return err
 	}
-	return nil
+	return removeAttachments(attachments)
 }
 
 /*
 Create the model in DB
+The content of new attachments is uploaded to GridFS first.
+The model is left as it is when the create fails, so that it can be retried. Its ID is set once the model is stored.
 */
-func Create(m Model) error {
-	session, err := newSession(m.DBConfig())
-	if session != nil {
-		defer session.Close()
+func Create(m Model, opts ...Option) error {
+	// A copy is hashed and encrypted, so that the model is left as it is on errors.
+	// The ID has to be known before encrypting as ciphertexts are bound to it.
+	doc := copyModel(m)
+	generateModelID(doc)
+	if err := hashFields(doc, nil); err != nil {
+		return err
 	}
-	if err != nil {
+	if err := encryptFields(doc); err != nil {
 		return err
 	}
-	encryptFields(m)
-	generateModelID(m)
-	c := fetchCollection(m, session)
-	if c == nil {
-		return ErrMongoCollectionNotFetched
+	id := fetchModelIDVal(doc)
+	op := newModelOperation("create", m, nil, opts)
+	uploaded, err := uploadAttachments(doc, gridFSFiles{op: op})
+	if err != nil {
+		return err
 	}
-	if err = c.Insert(m); err != nil {
+	// The document has an ID, so a retried insert cannot store it twice
+	op.idempotent = true
+	err = op.run(func(c *mgo.Collection) error {
+		err := c.Insert(doc)
+		if mgo.IsDup(err) && op.retried() {
+			// Inserted by the attempt which failed with a transient error
+			if n, countErr := c.FindId(id).Count(); countErr == nil && n == 1 {
+				err = nil
+			}
+		}
+		if err == nil {
+			op.documents = 1
+		}
+		return err
+	})
+	if err != nil {
+		rollbackAttachments(uploaded)
 		return err
 	}
+	setModelIDVal(m, id)
 
-	// Fetch stored values after saving
-	Find(m)
-
-	return nil
+	// Fetch stored values after saving, from the primary as a secondary might not have them yet
+	return Find(m, append(opts[:len(opts):len(opts)], WithReadPreference(mgo.Primary))...)
 }
 
 /*
@@ -118,23 +219,25 @@ FindBy returns a record of a model interface by a where clause passed to it.
 It expects an input of the whereClause as the shortened bson.M format.
 Check here : https://godoc.org/gopkg.in/mgo.v2/bson#M
 */
-func FindBy(whereClause bson.M, m Model) error {
-	session, err := newSession(m.DBConfig())
-	if session != nil {
-		defer session.Close()
-	}
+func FindBy(whereClause bson.M, m Model, opts ...Option) error {
+	op := newModelOperation("find_by", m, whereClause, opts)
+	op.idempotent = true
+	err := op.run(func(c *mgo.Collection) error {
+		q := c.Find(whereClause)
+		if len(op.sort) > 0 {
+			q.Sort(op.sort...)
+		}
+		if err := q.One(m); err != nil {
+			return err
+		}
+		op.documents = 1
+		bindAttachments(m, gridFSFiles{})
+		return decryptFields(m)
+	})
 	if err != nil {
 		return err
 	}
-	c := fetchCollection(m, session)
-	if c == nil {
-		return ErrMongoCollectionNotFetched
-	}
-	if err = c.Find(whereClause).One(m); err != nil {
-		return err
-	}
-	decryptFields(m)
-	return nil
+	return op.preloadFromMongo([]reflect.Value{reflect.ValueOf(m)})
 }
 
 /*
@@ -153,34 +256,70 @@ eg, to have no skips but to have a limit of 10
 FindMany("my_awesome_models", bson.M{"some_field": "some_field_value"}, &models, -1, 10)
 */
 func FindMany(whereClause bson.M, models Models, options ...int) error {
-	session, err := newSession(models.DBConfig())
-	if session != nil {
-		defer session.Close()
-	}
-	if err != nil {
-		return err
-	}
-	c := session.DB(models.DBConfig().DBName).C(models.CollectionName())
-	if c == nil {
-		return ErrMongoCollectionNotFetched
-	}
-	q := c.Find(whereClause)
-	limit := -1
-	skip := -1
+	var opts []Option
 	if len(options) > 0 {
-		skip = options[0]
+		opts = append(opts, Skip(options[0]))
 		if len(options) > 1 {
-			limit = options[1]
+			opts = append(opts, Limit(options[1]))
 		}
 	}
-	if skip > 0 {
-		q.Skip(skip)
-	}
-	if limit > 0 {
-		q.Limit(limit)
-	}
-	if err = q.All(models); err != nil {
+	return FindManyWithOptions(whereClause, models, opts...)
+}
+
+/*
+FindManyWithOptions is FindMany which takes Options, including Sort, Skip, Limit and Preload.
+Preloaded models are decrypted, unlike the models themselves.
+
+FindManyWithOptions(bson.M{"some_field": "some_field_value"}, &models, mgostore.Limit(10))
+*/
+func FindManyWithOptions(whereClause bson.M, models Models, opts ...Option) error {
+	op := newModelOperation("find_many", models, whereClause, opts)
+	op.idempotent = true
+	err := op.run(func(c *mgo.Collection) error {
+		q := c.Find(whereClause)
+		if len(op.sort) > 0 {
+			q.Sort(op.sort...)
+		}
+		if op.skip > 0 {
+			q.Skip(op.skip)
+		}
+		if op.limit > 0 {
+			q.Limit(op.limit)
+		}
+		if err := q.All(models); err != nil {
+			return err
+		}
+		op.documents = reflect.ValueOf(models).Elem().Len()
+		bindAllAttachments(models, gridFSFiles{})
+		return nil
+	})
+	if err != nil {
 		return err
 	}
-	return nil
+	return op.preloadFromMongo(modelPointers(models))
+}
+
+/*
+Count returns the number of records of the collection of the model which match the where clause.
+The model is only used for its collection and DB config.
+
+	n, err := mgostore.Count(bson.M{"some_field": "some_field_value"}, &MyAwesomeModel{})
+*/
+func Count(whereClause bson.M, m Model, opts ...Option) (int, error) {
+	var n int
+	op := newModelOperation("count", m, whereClause, opts)
+	op.idempotent = true
+	err := op.run(func(c *mgo.Collection) error {
+		q := c.Find(whereClause)
+		if op.skip > 0 {
+			q.Skip(op.skip)
+		}
+		if op.limit > 0 {
+			q.Limit(op.limit)
+		}
+		var err error
+		n, err = q.Count()
+		return err
+	})
+	return n, err
 }
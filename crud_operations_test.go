@@ -36,7 +36,7 @@ func TestCreate(t *testing.T) {
 
 	tc := testMongoCollection()
 	// Make sure to drop the entire collection after the test is run
-	defer tc.DropCollection()
+	defer DropCollection(&mockModel{})
 
 	assert.Equal(t, nil, err, "Expected no error")
 	assert.NotEqual(t, nil, m.ID, "Expected object Id to be generated")
@@ -47,6 +47,25 @@ func TestCreate(t *testing.T) {
 	assert.NotEqual(t, "crypto text", m.EncryptedField1, "Expected field to be encrypted in the DB")
 }
 
+func TestCreateUpdateFailureKeepsModel(t *testing.T) {
+	setTestEnvVars()
+	os.Setenv("MONGODB_SERVERS", "invalid_server")
+	defer setTestEnvVars()
+
+	t.Log("When the model cannot be created")
+	m := &mockModel{EncryptedField1: "crypto text", BcryptField: "password"}
+	assert.NotNil(t, Create(m), "Expected an error")
+	assert.Equal(t, bson.ObjectId(""), m.ID, "Expected no ID to be set")
+	assert.Equal(t, "crypto text", m.EncryptedField1, "Expected the field to stay plain text")
+	assert.Equal(t, "password", m.BcryptField, "Expected the field not to be hashed")
+
+	t.Log("When the model cannot be updated")
+	m.ID = bson.NewObjectId()
+	assert.NotNil(t, Update(m), "Expected an error")
+	assert.Equal(t, "crypto text", m.EncryptedField1, "Expected the field to stay plain text")
+	assert.Equal(t, "password", m.BcryptField, "Expected the field not to be hashed")
+}
+
 func TestDelete(t *testing.T) {
 	id := bson.NewObjectId()
 	m := &mockModel{ID: id}
@@ -64,7 +83,7 @@ func TestDelete(t *testing.T) {
 	t.Log("When connection can be established")
 	tc := testMongoCollection()
 	// Make sure to drop the entire collection after the test is run
-	defer tc.DropCollection()
+	defer DropCollection(&mockModel{})
 
 	t.Log("When record does not exist")
 	err = Destroy(m)
@@ -97,7 +116,7 @@ func TestUpdate(t *testing.T) {
 	t.Log("When connection can be established")
 	tc := testMongoCollection()
 	// Make sure to drop the entire collection after the test is run
-	defer tc.DropCollection()
+	defer DropCollection(&mockModel{})
 
 	tc.Insert(m)
 
@@ -142,7 +161,7 @@ func TestFind(t *testing.T) {
 	t.Log("When connection can be established")
 	tc := testMongoCollection()
 	// Make sure to drop the entire collection after the test is run
-	defer tc.DropCollection()
+	defer DropCollection(&mockModel{})
 	t.Log("When record does not exist")
 	err = Find(m)
 	assert.Equal(t, ErrRecordNotFound, err, "Expected not found error")
@@ -170,7 +189,7 @@ func TestFindBy(t *testing.T) {
 	t.Log("When connection can be established")
 	tc := testMongoCollection()
 	// Make sure to drop the entire collection after the test is run
-	defer tc.DropCollection()
+	defer DropCollection(&mockModel{})
 	id := bson.NewObjectId()
 	m.ID = id
 	m.NumField = 42
@@ -201,7 +220,7 @@ func TestFindMany(t *testing.T) {
 	t.Log("When connection can be established")
 	tc := testMongoCollection()
 	// Make sure to drop the entire collection after the test is run
-	defer tc.DropCollection()
+	defer DropCollection(&mockModel{})
 	var mIds []bson.ObjectId
 	for i := 0; i < 3; i++ {
 		m := &mockModel{NumField: 42, EncryptedField1: "encrypted text"}
@@ -231,3 +250,30 @@ func TestFindMany(t *testing.T) {
 	assert.Equal(t, mIds[1], models[0].ID)
 	assert.Equal(t, mIds[2], models[1].ID)
 }
+
+func TestCount(t *testing.T) {
+	t.Log("When no connection can be established")
+	setTestEnvVars()
+	os.Setenv("MONGODB_SERVERS", "invalid_server")
+	_, err := Count(bson.M{"num_field": 42}, &mockModel{})
+	assert.Equal(t,
+		"no reachable servers",
+		err.Error(),
+		"Expected not reachable servers error")
+	setTestEnvVars()
+	t.Log("When connection can be established")
+	tc := testMongoCollection()
+	// Make sure to drop the entire collection after the test is run
+	defer DropCollection(&mockModel{})
+	for i := 0; i < 3; i++ {
+		tc.Insert(&mockModel{ID: bson.NewObjectId(), NumField: 42})
+	}
+	n, err := Count(bson.M{"num_field": 42}, &mockModel{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	t.Log("When limit is passed")
+	n, err = Count(bson.M{"num_field": 42}, &mockModel{}, Limit(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
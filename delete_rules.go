@@ -0,0 +1,202 @@
+package mgostore
+
+import (
+	"reflect"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+DeleteRule is what Destroy does with the models which reference the destroyed model.
+It is declared with ondelete in the ref tag of a has-many relation:
+
+	Orders []Order `bson:"-" ref:"orders,foreign=user_id,ondelete=cascade"`
+
+Mongo has no transactions here, so the rules are applied one after the other.
+All restrictions are checked first, including those of cascaded models, so that
+nothing is changed when the model cannot be destroyed.
+*/
+type DeleteRule string
+
+const (
+	// DeleteCascade destroys the referencing models, applying their own delete rules
+	DeleteCascade DeleteRule = "cascade"
+	// DeleteNullify sets the reference of the referencing models to null
+	DeleteNullify DeleteRule = "nullify"
+	// DeleteRestrict fails Destroy with a DeleteRestrictedError while there are referencing models
+	DeleteRestrict DeleteRule = "restrict"
+)
+
+// dependentStore is how delete rules read and change the referencing models of a store
+type dependentStore interface {
+	loadRelated(m Model, collection string, filter bson.M, out interface{}) error
+	nullifyDependents(m Model, collection string, key string, id interface{}) error
+	destroyDependent(m Model) error
+}
+
+/*
+deleteRelations returns the has-many relations of the model type which declare a delete rule.
+It returns ErrInvalidDeleteRule when a rule is unknown or declared on another kind of relation,
+so that a typo never silently skips the rule.
+*/
+func deleteRelations(t reflect.Type) ([]*relation, error) {
+	var rels []*relation
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch parseRefTag(f.Tag.Get("ref")).onDelete {
+		case "":
+			continue
+		case DeleteCascade, DeleteNullify, DeleteRestrict:
+		default:
+			return nil, ErrInvalidDeleteRule
+		}
+		rel, err := fetchRelation(t, f.Name)
+		if err != nil || rel.ref.foreign == "" || rel.field.Type.Kind() != reflect.Slice {
+			return nil, ErrInvalidDeleteRule
+		}
+		rels = append(rels, rel)
+	}
+	return rels, nil
+}
+
+// loadDependents returns pointers to the models which reference the ID by the relation
+func loadDependents(rel *relation, id interface{}, store dependentStore) ([]reflect.Value, error) {
+	out := reflect.New(reflect.SliceOf(rel.elemType))
+	prototype := reflect.New(rel.elemType).Interface().(Model)
+	if err := store.loadRelated(prototype, rel.ref.collection, bson.M{rel.ref.foreign: id}, out.Interface()); err != nil {
+		return nil, err
+	}
+	return modelPointers(out.Interface()), nil
+}
+
+/*
+enforceDeleteRules applies the delete rules of the model before it is destroyed,
+or returns a DeleteRestrictedError without changing anything.
+*/
+func enforceDeleteRules(m Model, store dependentStore) error {
+	rels, err := deleteRelations(reflect.TypeOf(m).Elem())
+	if err != nil || len(rels) == 0 {
+		return err
+	}
+	restricted, err := collectRestrictions(m, store, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	if len(restricted) > 0 {
+		return &DeleteRestrictedError{Dependents: restricted}
+	}
+	id := fetchModelIDVal(m)
+	for _, rel := range rels {
+		switch rel.ref.onDelete {
+		case DeleteCascade:
+			dependents, err := loadDependents(rel, id, store)
+			if err != nil {
+				return err
+			}
+			for _, d := range dependents {
+				if err = store.destroyDependent(d.Interface().(Model)); err != nil {
+					return err
+				}
+			}
+		case DeleteNullify:
+			prototype := reflect.New(rel.elemType).Interface().(Model)
+			if err := store.nullifyDependents(prototype, rel.ref.collection, rel.ref.foreign, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectRestrictions returns the models which restrict destroying the model, also through cascades
+func collectRestrictions(m Model, store dependentStore, seen map[string]bool) ([]Dependent, error) {
+	id := fetchModelIDVal(m)
+	key := m.CollectionName() + "/" + idString(id)
+	if seen[key] {
+		return nil, nil
+	}
+	seen[key] = true
+	rels, err := deleteRelations(reflect.TypeOf(m).Elem())
+	if err != nil {
+		return nil, err
+	}
+	var restricted []Dependent
+	for _, rel := range rels {
+		if rel.ref.onDelete != DeleteRestrict && rel.ref.onDelete != DeleteCascade {
+			continue
+		}
+		dependents, err := loadDependents(rel, id, store)
+		if err != nil {
+			return nil, err
+		}
+		if len(dependents) == 0 {
+			continue
+		}
+		if rel.ref.onDelete == DeleteRestrict {
+			d := Dependent{Collection: rel.ref.collection, Key: rel.ref.foreign}
+			for _, dm := range dependents {
+				d.IDs = append(d.IDs, fetchModelIDVal(dm.Interface().(Model)))
+			}
+			restricted = append(restricted, d)
+			continue
+		}
+		for _, dm := range dependents {
+			more, err := collectRestrictions(dm.Interface().(Model), store, seen)
+			if err != nil {
+				return nil, err
+			}
+			restricted = append(restricted, more...)
+		}
+	}
+	return restricted, nil
+}
+
+// mongoDependents is the dependentStore of Destroy
+type mongoDependents struct {
+	op   *operation
+	opts []Option
+}
+
+func (d mongoDependents) loadRelated(m Model, collection string, filter bson.M, out interface{}) error {
+	// Read from the primary, a secondary might miss recently created references
+	op := *d.op
+	primary := mgo.Primary
+	op.mode = &primary
+	return op.mongoLoader("load_dependents")(m, collection, filter, out)
+}
+
+func (d mongoDependents) nullifyDependents(m Model, collection string, key string, id interface{}) error {
+	filter := bson.M{key: id}
+	op := d.op.relatedOperation("nullify", m, collection, filter)
+	op.writeConcern = d.op.writeConcern
+	// Setting null again has the same effect
+	op.idempotent = true
+	var cached []bson.M
+	err := op.run(func(c *mgo.Collection) error {
+		if _, ok := m.(CachedModel); ok {
+			// The cached documents of the dependents are invalidated after the update
+			if err := c.Find(filter).Select(bson.M{"_id": 1}).All(&cached); err != nil {
+				return err
+			}
+		}
+		info, err := c.UpdateAll(filter, bson.M{"$set": bson.M{key: nil}})
+		if info != nil {
+			op.documents = info.Updated
+		}
+		return err
+	})
+	for _, doc := range cached {
+		invalidateCache(m, doc["_id"])
+	}
+	return err
+}
+
+func (d mongoDependents) destroyDependent(m Model) error {
+	err := Destroy(m, d.opts...)
+	if err == ErrRecordNotFound {
+		// Destroyed by another cascade
+		return nil
+	}
+	return err
+}
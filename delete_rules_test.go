@@ -0,0 +1,119 @@
+package mgostore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type ruleAuthor struct {
+	ID       bson.ObjectId `bson:"_id,omitempty"`
+	Posts    []rulePost    `bson:"-" ref:"rule_posts,foreign=author_id,ondelete=cascade"`
+	Comments []ruleComment `bson:"-" ref:"rule_comments,foreign=author_id,ondelete=nullify"`
+}
+
+type rulePost struct {
+	ID       bson.ObjectId `bson:"_id,omitempty"`
+	AuthorID bson.ObjectId `bson:"author_id"`
+	Likes    []ruleLike    `bson:"-" ref:"rule_likes,foreign=post_id,ondelete=restrict"`
+}
+
+type ruleComment struct {
+	ID       bson.ObjectId `bson:"_id,omitempty"`
+	AuthorID bson.ObjectId `bson:"author_id,omitempty"`
+}
+
+type ruleLike struct {
+	ID     bson.ObjectId `bson:"_id,omitempty"`
+	PostID bson.ObjectId `bson:"post_id"`
+}
+
+func (m *ruleAuthor) CollectionName() string  { return "rule_authors" }
+func (m *ruleAuthor) DBConfig() *MongoConfig  { return testMongoConfig() }
+func (m *rulePost) CollectionName() string    { return "rule_posts" }
+func (m *rulePost) DBConfig() *MongoConfig    { return testMongoConfig() }
+func (m *ruleComment) CollectionName() string { return "rule_comments" }
+func (m *ruleComment) DBConfig() *MongoConfig { return testMongoConfig() }
+func (m *ruleLike) CollectionName() string    { return "rule_likes" }
+func (m *ruleLike) DBConfig() *MongoConfig    { return testMongoConfig() }
+
+func Test_deleteRelations(t *testing.T) {
+	rels, err := deleteRelations(reflect.TypeOf(ruleAuthor{}))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rels))
+	assert.Equal(t, DeleteCascade, rels[0].ref.onDelete)
+	assert.Equal(t, DeleteNullify, rels[1].ref.onDelete)
+
+	t.Log("When the model has no delete rules")
+	rels, err = deleteRelations(reflect.TypeOf(preloadUser{}))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rels))
+
+	t.Log("When the delete rule is unknown")
+	type misspelledRule struct {
+		Posts []rulePost `bson:"-" ref:"rule_posts,foreign=author_id,ondelete=cascde"`
+	}
+	_, err = deleteRelations(reflect.TypeOf(misspelledRule{}))
+	assert.Equal(t, ErrInvalidDeleteRule, err)
+
+	t.Log("When the delete rule is declared on a belongs-to relation")
+	type localRule struct {
+		AuthorID bson.ObjectId `bson:"author_id"`
+		Author   *ruleAuthor   `bson:"-" ref:"rule_authors,local=author_id,ondelete=cascade"`
+	}
+	_, err = deleteRelations(reflect.TypeOf(localRule{}))
+	assert.Equal(t, ErrInvalidDeleteRule, err)
+
+	t.Log("When the delete rule is declared on a malformed ref")
+	type malformedRule struct {
+		Posts []rulePost `bson:"-" ref:"rule_posts,ondelete=cascade"`
+	}
+	_, err = deleteRelations(reflect.TypeOf(malformedRule{}))
+	assert.Equal(t, ErrInvalidDeleteRule, err)
+
+	t.Log("When the delete rule is declared on a has-one relation")
+	type hasOneRule struct {
+		Post *rulePost `bson:"-" ref:"rule_posts,foreign=author_id,ondelete=cascade"`
+	}
+	_, err = deleteRelations(reflect.TypeOf(hasOneRule{}))
+	assert.Equal(t, ErrInvalidDeleteRule, err)
+}
+
+func TestDestroyDeleteRules(t *testing.T) {
+	setTestEnvVars()
+	s := NewMemoryStore()
+	author := &ruleAuthor{}
+	s.Create(author)
+	post := &rulePost{AuthorID: author.ID}
+	s.Create(post)
+	comment := &ruleComment{AuthorID: author.ID}
+	s.Create(comment)
+	like := &ruleLike{PostID: post.ID}
+	s.Create(like)
+
+	t.Log("When a cascaded model is restricted")
+	err := s.Destroy(author)
+	assert.True(t, errors.Is(err, ErrDeleteRestricted), "Expected a restricted delete")
+	var restrictedErr *DeleteRestrictedError
+	assert.True(t, errors.As(err, &restrictedErr))
+	assert.Equal(t, []Dependent{{Collection: "rule_likes", Key: "post_id", IDs: []interface{}{like.ID}}}, restrictedErr.Dependents)
+	assert.Equal(t, "cannot destroy, referenced by rule_likes.post_id of "+like.ID.Hex(), err.Error())
+	assert.Nil(t, s.Find(&ruleAuthor{ID: author.ID}), "Expected nothing to be destroyed")
+	assert.Nil(t, s.Find(&rulePost{ID: post.ID}), "Expected nothing to be cascaded")
+
+	t.Log("When nothing restricts the delete")
+	assert.Nil(t, s.Destroy(like))
+	assert.Nil(t, s.Destroy(author))
+	assert.Equal(t, ErrRecordNotFound, s.Find(&ruleAuthor{ID: author.ID}))
+	assert.Equal(t, ErrRecordNotFound, s.Find(&rulePost{ID: post.ID}), "Expected the posts to be destroyed")
+	found := &ruleComment{ID: comment.ID}
+	assert.Nil(t, s.Find(found), "Expected the comments to be kept")
+	assert.Equal(t, bson.ObjectId(""), found.AuthorID, "Expected the reference to be nullified")
+	doc := s.collections["mgostore_test.rule_comments"][0]
+	value, exists := doc["author_id"]
+	assert.True(t, exists)
+	assert.Nil(t, value, "Expected the reference to be null")
+}
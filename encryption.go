@@ -6,50 +6,150 @@ import (
 	"github.com/gsingharoy/mgostore/lib"
 )
 
-func encryptFields(m Model) error {
-	s := reflect.ValueOf(m).Elem()
+// EncryptedFieldSpec describes a field which is stored encrypted in the documents of a collection
+type EncryptedFieldSpec struct {
+	// bson name of the field
+	Name string
+	// Name of the cipher, as used in the encrypt tag
+	Cipher string
+	// Name of the key in the CryptoConfig. Empty for the default key
+	Key string
+}
+
+/*
+EncryptedFieldSpecs returns the specs of all encrypted fields of a model as declared by
+its encrypt tags and Secret fields.
+*/
+func EncryptedFieldSpecs(m Model) []EncryptedFieldSpec {
+	var specs []EncryptedFieldSpec
 	t := reflect.TypeOf(m).Elem()
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
-		vField := s.Field(i)
-		if f.Type == reflect.TypeOf("") && len(vField.String()) > 0 {
-			// encrypted with aes algorithm
-			if f.Tag.Get("encrypt") == "aes" {
-				cryptoConfig := m.DBConfig().CryptoConfig
-				if cryptoConfig == nil {
-					return ErrMissingCryptoSecret
-				}
-				encryptedvalue, err := lib.AesEncrypt(cryptoConfig.AESSecret, vField.String())
-				if err != nil {
-					return err
-				}
-				vField.SetString(encryptedvalue)
-			}
+		if !isEncryptableType(f.Type) {
+			continue
 		}
+		cipherName, keyName := parseEncryptTag(encryptionTag(f))
+		if cipherName == "" {
+			continue
+		}
+		specs = append(specs, EncryptedFieldSpec{Name: fieldBSONName(f), Cipher: cipherName, Key: keyName})
 	}
-	return nil
+	return specs
+}
+
+// encryptedField is a field of a model which holds a value to be encrypted or decrypted
+type encryptedField struct {
+	field  reflect.StructField
+	value  reflect.Value
+	cipher Cipher
+	key    []byte
+}
+
+func encryptFields(m Model) error {
+	return encryptFieldsWith(m, m.DBConfig().CryptoConfig)
 }
 
 func decryptFields(m Model) error {
+	return decryptFieldsWith(m, m.DBConfig().CryptoConfig)
+}
+
+// encryptFieldsWith encrypts the fields of the model with the keys of the given config
+func encryptFieldsWith(m Model, cryptoConfig *CryptoConfig) error {
+	fields, err := fetchEncryptedFields(m, cryptoConfig)
+	if err != nil {
+		return err
+	}
+	id := modelIDString(m)
+	for _, ef := range fields {
+		encryptedvalue, err := ef.cipher.Encrypt(ef.key, ef.value.String(), associatedData(id, fieldBSONName(ef.field)))
+		if err != nil {
+			return err
+		}
+		ef.value.SetString(encryptedvalue)
+	}
+	return nil
+}
+
+/*
+decryptFieldsWith decrypts the fields of the model with the keys of the given config.
+Values which cannot be decrypted are reported as a DecryptionError, which wraps
+ErrCiphertextMismatch when the value does not belong to the document and field.
+*/
+func decryptFieldsWith(m Model, cryptoConfig *CryptoConfig) error {
+	fields, err := fetchEncryptedFields(m, cryptoConfig)
+	if err != nil {
+		return err
+	}
+	id := modelIDString(m)
+	for _, ef := range fields {
+		decryptedValue, err := ef.cipher.Decrypt(ef.key, ef.value.String(), associatedData(id, fieldBSONName(ef.field)))
+		if err == lib.ErrAuthenticationFailed {
+			err = ErrCiphertextMismatch
+		}
+		if err != nil {
+			return &DecryptionError{Field: fieldBSONName(ef.field), Err: err}
+		}
+		ef.value.SetString(decryptedValue)
+	}
+	return nil
+}
+
+/*
+fetchEncryptedFields returns the fields of the model which hold a value to be encrypted or decrypted,
+along with the cipher and key selected by their encrypt tag.
+All fields are resolved before any of them is touched, so that a model is never left
+partly encrypted because of an unknown cipher or a missing key.
+*/
+func fetchEncryptedFields(m Model, cryptoConfig *CryptoConfig) ([]encryptedField, error) {
+	var fields []encryptedField
 	s := reflect.ValueOf(m).Elem()
 	t := reflect.TypeOf(m).Elem()
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
 		vField := s.Field(i)
-		if f.Type == reflect.TypeOf("") && len(vField.String()) > 0 {
-			// encrypted with aes algorithm
-			if f.Tag.Get("encrypt") == "aes" {
-				cryptoConfig := m.DBConfig().CryptoConfig
-				if cryptoConfig == nil {
-					return ErrMissingCryptoSecret
-				}
-				decryptedValue, err := lib.AesDecrypt(cryptoConfig.AESSecret, vField.String())
-				if err != nil {
-					return err
-				}
-				vField.SetString(decryptedValue)
-			}
+		if !isEncryptableType(f.Type) || len(vField.String()) == 0 {
+			continue
+		}
+		cipherName, keyName := parseEncryptTag(encryptionTag(f))
+		if cipherName == "" {
+			continue
+		}
+		c, err := resolveCipher(cipherName, cryptoConfig)
+		if err != nil {
+			return nil, err
+		}
+		if cryptoConfig == nil {
+			return nil, ErrMissingCryptoSecret
 		}
+		key, err := cryptoConfig.key(keyName)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, encryptedField{field: f, value: vField, cipher: c, key: key})
 	}
-	return nil
+	return fields, nil
+}
+
+// isEncryptableType reports if a field of the type can hold an encrypted value
+func isEncryptableType(t reflect.Type) bool {
+	return t == reflect.TypeOf("") || t == reflect.TypeOf(Secret(""))
+}
+
+// encryptionTag returns the encrypt tag of the field.
+// Secret fields are encrypted with aes unless their tag says otherwise.
+func encryptionTag(f reflect.StructField) string {
+	tag := f.Tag.Get("encrypt")
+	if tag == "" && f.Type == reflect.TypeOf(Secret("")) {
+		return "aes"
+	}
+	return tag
+}
+
+/*
+associatedData returns the data which is authenticated along with an encrypted field.
+It binds the ciphertext to the document ID and the bson name of the field, so that
+an encrypted value copied to another document or another field fails to decrypt.
+*/
+func associatedData(id string, fieldName string) []byte {
+	return []byte(id + "\x00" + fieldName)
 }
@@ -1,10 +1,13 @@
 package mgostore
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/gsingharoy/mgostore/lib"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func Test_encryptFields(t *testing.T) {
@@ -13,8 +16,16 @@ func Test_encryptFields(t *testing.T) {
 		PlainTextField:  "plain text",
 		NumField:        42,
 		EncryptedField2: "encrypted text"}
-	encryptFields(m)
+	t.Log("When a field has an invalid type of encrypt")
+	err := encryptFields(m)
+	assert.Equal(t, ErrUnknownCipher, err, "Expected unknown cipher error")
+	assert.Equal(t,
+		"encrypted text",
+		m.EncryptedField2,
+		"Expected invalid type of encrypt to be not encrypted")
 
+	m.EncryptedField2 = ""
+	encryptFields(m)
 	assert.Equal(t,
 		"plain text",
 		m.PlainTextField,
@@ -23,10 +34,6 @@ func Test_encryptFields(t *testing.T) {
 		42,
 		m.NumField,
 		"Expected non string field to not change event though they have encrypt tag")
-	assert.Equal(t,
-		"encrypted text",
-		m.EncryptedField2,
-		"Expected invalid type of encrypt to be not encrypted")
 	assert.Equal(t,
 		0,
 		len(m.EncryptedField1),
@@ -35,7 +42,8 @@ func Test_encryptFields(t *testing.T) {
 	m.EncryptedField1 = "now encrypt!"
 	encryptFields(m)
 	key := []byte(testEncryptionSecret)
-	decryptedText, _ := lib.AesDecrypt(key, m.EncryptedField1)
+	assert.True(t, strings.HasPrefix(m.EncryptedField1, aesGCMPrefix), "Expected the AES-GCM format")
+	decryptedText, _ := lib.AesGcmDecrypt(key, strings.TrimPrefix(m.EncryptedField1, aesGCMPrefix), []byte(m.ID.Hex()+"\x00encrypted_field1"))
 	assert.Equal(t, "now encrypt!", decryptedText, "Expected decryption of encrypted text to match")
 }
 
@@ -44,6 +52,15 @@ func Test_decryptFields(t *testing.T) {
 		PlainTextField:  "plain text",
 		NumField:        42,
 		EncryptedField2: "encrypted text"}
+	t.Log("When a field has an invalid type of encrypt")
+	err := decryptFields(m)
+	assert.Equal(t, ErrUnknownCipher, err, "Expected unknown cipher error")
+	assert.Equal(t,
+		"encrypted text",
+		m.EncryptedField2,
+		"Expected invalid type of encrypt to be not decrypted")
+
+	m.EncryptedField2 = ""
 	decryptFields(m)
 	assert.Equal(t,
 		"plain text",
@@ -53,19 +70,58 @@ func Test_decryptFields(t *testing.T) {
 		42,
 		m.NumField,
 		"Expected non string field to not change event though they have encrypt tag")
-	assert.Equal(t,
-		"encrypted text",
-		m.EncryptedField2,
-		"Expected invalid type of encrypt to be not decrypted")
 	assert.Equal(t,
 		0,
 		len(m.EncryptedField1),
 		"Expect empty field with valid encrypt tag to not be decrypted")
 
 	key := []byte(testEncryptionSecret)
-	encryptedText, _ := lib.AesEncrypt(key, "encrypt this!")
-	m.EncryptedField1 = encryptedText
+	encryptedText, _ := lib.AesGcmEncrypt(key, "encrypt this!", []byte(m.ID.Hex()+"\x00encrypted_field1"))
+	m.EncryptedField1 = aesGCMPrefix + encryptedText
 	decryptFields(m)
 
 	assert.Equal(t, "encrypt this!", m.EncryptedField1, "Expected decryption of encrypted field to match")
+
+	t.Log("When the value was stored with AES-CFB by an older version")
+	legacyText, _ := lib.AesEncrypt(key, "legacy value")
+	m.EncryptedField1 = legacyText
+	err = decryptFields(m)
+	assert.True(t, errors.Is(err, ErrUnauthenticatedCiphertext), "Expected legacy values to be refused by default")
+	assert.Equal(t, legacyText, m.EncryptedField1)
+
+	t.Log("When legacy values are enabled")
+	cryptoConfig := *m.DBConfig().CryptoConfig
+	cryptoConfig.LegacyCFB = true
+	assert.Nil(t, decryptFieldsWith(m, &cryptoConfig), "Expected no error")
+	assert.Equal(t, "legacy value", m.EncryptedField1, "Expected legacy values to be decrypted")
+
+	t.Log("When the prefix is stripped from an AES-GCM value")
+	m.EncryptedField1 = "now encrypt!"
+	encryptFields(m)
+	m.EncryptedField1 = strings.TrimPrefix(m.EncryptedField1, aesGCMPrefix)
+	assert.NotNil(t, decryptFields(m), "Expected the stripped value to fail")
+}
+
+func Test_decryptFieldsMovedCiphertext(t *testing.T) {
+	m := &mockModel{ID: bson.NewObjectId(), EncryptedField1: "secret"}
+	encryptFields(m)
+
+	t.Log("When the ciphertext is decrypted in its own document")
+	same := &mockModel{ID: m.ID, EncryptedField1: m.EncryptedField1}
+	assert.Nil(t, decryptFields(same))
+	assert.Equal(t, "secret", same.EncryptedField1)
+
+	t.Log("When the ciphertext is copied to another document")
+	other := &mockModel{ID: bson.NewObjectId(), EncryptedField1: m.EncryptedField1}
+	err := decryptFields(other)
+	assert.True(t, errors.Is(err, ErrCiphertextMismatch), "Expected ciphertext mismatch error")
+	var decryptionErr *DecryptionError
+	assert.True(t, errors.As(err, &decryptionErr), "Expected a decryption error")
+	assert.Equal(t, "encrypted_field1", decryptionErr.Field)
+
+	t.Log("When the ciphertext is copied to another field")
+	key := []byte(testEncryptionSecret)
+	encryptedText, _ := lib.AesGcmEncrypt(key, "secret", []byte(m.ID.Hex()+"\x00plain_text_field"))
+	other = &mockModel{ID: m.ID, EncryptedField1: aesGCMPrefix + encryptedText}
+	assert.True(t, errors.Is(decryptFields(other), ErrCiphertextMismatch), "Expected ciphertext mismatch error")
 }
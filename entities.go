@@ -1,6 +1,10 @@
 package mgostore
 
-import "time"
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
 
 /*
 MongoConfig struct determines the connection to the mongo DB.
@@ -11,15 +15,103 @@ type MongoConfig struct {
 	// Define the DB Name for which the corresponding model would connect to
 	DBName  string
 	Timeout time.Duration
+	// Maximum number of sockets in use per server. Defaults to the limit of mgo, 4096
+	PoolLimit int
 	// Specifies if the connection is SSL. This is important to use a tls.Dial function in that case due to limitations of mgo package
 	IsSSL bool
+	// TLS options of the connection. Setting it implies IsSSL
+	TLS *TLSConfig
+	// Credentials to authenticate with. They take precedence over credentials in the Servers URL,
+	// which end up wherever the URL is logged
+	Username string
+	Password string
+	// Database the user is defined in. Defaults to the database in the Servers URL, or admin
+	AuthSource string
+	// Authentication mechanism, one of AuthSCRAMSHA1, AuthMongoDBCR or AuthX509
+	AuthMechanism string
+	// Provides the credentials when they should not be part of the config, eg. EnvCredentials or FileCredentials
+	CredentialProvider CredentialProvider
+	// Consistency mode of the sessions, mgo.Strong, mgo.Monotonic or mgo.Eventual. Defaults to mgo.Strong
+	Consistency *mgo.Mode
+	// Read preference of the sessions, eg. mgo.SecondaryPreferred. It takes precedence over Consistency
+	ReadPreference *mgo.Mode
+	// Acknowledgement required for writes. Defaults to writes acknowledged by the primary
+	WriteConcern *WriteConcern
+	// Retries operations after transient errors. Operations are not retried when it is nil
+	RetryPolicy *RetryPolicy
+	// Fails operations fast while the cluster is unreachable. There is no circuit breaker when it is nil
+	CircuitBreaker *CircuitBreakerConfig
+	// Notified after every operation, eg. SlowOperationLogger
+	Observers []Observer
+	// Traces every operation in a span. Defaults to no tracing
+	Tracer Tracer
 	// configuration keys for encryption and decryption
 	CryptoConfig *CryptoConfig
 }
 
+// WriteConcern represents the acknowledgement required for writes
+type WriteConcern struct {
+	// Number of servers which have to acknowledge the write
+	W int
+	// Mode of acknowledgement, eg. "majority". It takes precedence over W
+	WMode string
+	// Waits for the write to be committed to the journal
+	J bool
+	// Time to wait for the acknowledgement. Zero waits forever
+	WTimeout time.Duration
+}
+
+// safe returns the write concern in the form of mgo
+func (wc *WriteConcern) safe() *mgo.Safe {
+	return &mgo.Safe{
+		W:        wc.W,
+		WMode:    wc.WMode,
+		J:        wc.J,
+		WTimeout: int(wc.WTimeout / time.Millisecond),
+	}
+}
+
+// TLSConfig represents the TLS options of the connection to the mongo DB
+type TLSConfig struct {
+	// PEM encoded CA certificates to verify the servers with, from a file or inline.
+	// The system roots are used when both are empty
+	CAFile string
+	CAPEM  []byte
+	// PEM encoded client certificate and private key, from files or inline, eg. for X.509 authentication
+	CertFile string
+	KeyFile  string
+	CertPEM  []byte
+	KeyPEM   []byte
+	// Name the server certificates are verified against, when it differs from the host in Servers
+	ServerName string
+	// Minimum TLS version, eg. tls.VersionTLS12
+	MinVersion uint16
+	// Skips the verification of the server certificates. Only ever use this for local development
+	InsecureSkipVerify bool
+}
+
 // CryptoConfig represents the configuration keys of encryption secret
 type CryptoConfig struct {
+	// The default key, used by encrypted fields which do not name a key
 	AESSecret []byte
+	// Named keys which fields select with the encrypt tag, eg. `encrypt:"chacha20,key=pii"`
+	Keys map[string][]byte
+	// Decrypts values of "aes" fields which older versions stored with AES-CFB. Those are not
+	// authenticated, so a wrong key or a value moved to another document decrypts to garbage
+	// without an error. Only enable it until the collections are re-encrypted
+	LegacyCFB bool
+}
+
+// key returns the named key, or the default key when name is empty
+func (c *CryptoConfig) key(name string) ([]byte, error) {
+	if name == "" {
+		return c.AESSecret, nil
+	}
+	key, ok := c.Keys[name]
+	if !ok {
+		return nil, ErrMissingCryptoSecret
+	}
+	return key, nil
 }
 
 /*
@@ -0,0 +1,211 @@
+package mgostore
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+/*
+Typed errors returned by the CRUD functions. They wrap the underlying mgo error,
+so both errors.Is(err, ErrDuplicateKey) and errors.As(err, &dupErr) work.
+
+	var dupErr *mgostore.DuplicateKeyError
+	if errors.As(err, &dupErr) {
+		log.Printf("%s is taken", dupErr.Key)
+	}
+*/
+
+// DuplicateKeyError is returned when a write violates a unique index
+type DuplicateKeyError struct {
+	// Name of the violated index, eg. email_1
+	Index string
+	// Duplicated key as reported by mongo, eg. { email: "user@example.com" }
+	Key string
+	Err error
+}
+
+func (e *DuplicateKeyError) Error() string { return e.Err.Error() }
+
+func (e *DuplicateKeyError) Unwrap() error { return e.Err }
+
+func (e *DuplicateKeyError) Is(target error) bool { return target == ErrDuplicateKey }
+
+// DecryptionError is returned when an encrypted field cannot be decrypted
+type DecryptionError struct {
+	// bson name of the field
+	Field string
+	Err   error
+}
+
+func (e *DecryptionError) Error() string {
+	return "cannot decrypt field " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *DecryptionError) Unwrap() error { return e.Err }
+
+func (e *DecryptionError) Is(target error) bool { return target == ErrDecryption }
+
+// TimeoutError is returned when an operation or its write concern timed out
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string { return e.Err.Error() }
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// ConnectionError is returned when the servers cannot be reached or the connection broke
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string { return e.Err.Error() }
+
+func (e *ConnectionError) Unwrap() error { return e.Err }
+
+func (e *ConnectionError) Is(target error) bool { return target == ErrConnection }
+
+// ValidationError is returned when a document is rejected by the validator of its collection
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+// Dependent lists the models of a collection which reference a model by a key
+type Dependent struct {
+	Collection string
+	Key        string
+	IDs        []interface{}
+}
+
+// DeleteRestrictedError is returned by Destroy while models with a restrict delete rule reference the model
+type DeleteRestrictedError struct {
+	Dependents []Dependent
+}
+
+func (e *DeleteRestrictedError) Error() string {
+	parts := make([]string, len(e.Dependents))
+	for i, d := range e.Dependents {
+		ids := make([]string, len(d.IDs))
+		for j, id := range d.IDs {
+			ids[j] = idString(id)
+		}
+		parts[i] = fmt.Sprintf("%s.%s of %s", d.Collection, d.Key, strings.Join(ids, ", "))
+	}
+	return "cannot destroy, referenced by " + strings.Join(parts, "; ")
+}
+
+func (e *DeleteRestrictedError) Is(target error) bool { return target == ErrDeleteRestricted }
+
+// Server error codes by the typed error they are wrapped in
+var (
+	timeoutErrorCodes = map[int]bool{
+		50: true, // MaxTimeMSExpired
+		89: true, // NetworkTimeout
+	}
+	connectionErrorCodes = map[int]bool{
+		6:    true, // HostUnreachable
+		7:    true, // HostNotFound
+		9001: true, // SocketException
+	}
+	validationErrorCode = 121 // DocumentValidationFailure
+)
+
+// Index and key in the message of a duplicate key error, eg. "index: email_1 dup key: { : "x" }"
+var duplicateKeyPattern = regexp.MustCompile(`index: (\S+)\s+dup key: (\{.*\})`)
+
+/*
+wrapError wraps an error returned by mgo in the matching typed error.
+ErrRecordNotFound and errors which do not match any type are returned as they are.
+*/
+func wrapError(err error) error {
+	if err == nil || err == mgo.ErrNotFound {
+		return err
+	}
+	switch err.(type) {
+	case *DuplicateKeyError, *DecryptionError, *TimeoutError, *ConnectionError, *ValidationError, *DeleteRestrictedError:
+		return err
+	}
+	code, message := errorCode(err)
+	switch {
+	case mgo.IsDup(err):
+		return newDuplicateKeyError(err, message)
+	case code == validationErrorCode:
+		return &ValidationError{Err: err}
+	case isTimeout(err, code):
+		return &TimeoutError{Err: err}
+	case isConnectionError(err, code):
+		return &ConnectionError{Err: err}
+	}
+	return err
+}
+
+// errorCode returns the server error code and message of an mgo error
+func errorCode(err error) (int, string) {
+	switch e := err.(type) {
+	case *mgo.QueryError:
+		return e.Code, e.Message
+	case *mgo.LastError:
+		return e.Code, e.Err
+	}
+	return 0, err.Error()
+}
+
+// hasErrorCode reports if the error is a server error with the code
+func hasErrorCode(err error, code int) bool {
+	if err == nil {
+		return false
+	}
+	c, _ := errorCode(err)
+	return c == code
+}
+
+func newDuplicateKeyError(err error, message string) *DuplicateKeyError {
+	dupErr := &DuplicateKeyError{Err: err}
+	if match := duplicateKeyPattern.FindStringSubmatch(message); match != nil {
+		// Older servers report the index as db.collection.$name
+		index := match[1]
+		if i := strings.LastIndex(index, ".$"); i >= 0 {
+			index = index[i+2:]
+		}
+		dupErr.Index = index
+		dupErr.Key = match[2]
+	}
+	return dupErr
+}
+
+func isTimeout(err error, code int) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if lastErr, ok := err.(*mgo.LastError); ok && lastErr.WTimeout {
+		return true
+	}
+	return timeoutErrorCodes[code] || strings.HasSuffix(err.Error(), "i/o timeout")
+}
+
+func isConnectionError(err error, code int) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	switch err.Error() {
+	case "no reachable servers", "Closed explicitly", "EOF":
+		return true
+	}
+	return connectionErrorCodes[code]
+}
@@ -0,0 +1,81 @@
+package mgostore
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+type timeoutNetError struct{}
+
+func (timeoutNetError) Error() string   { return "read tcp: i/o timeout" }
+func (timeoutNetError) Timeout() bool   { return true }
+func (timeoutNetError) Temporary() bool { return true }
+
+func Test_wrapError(t *testing.T) {
+	t.Log("When there is no error or the record is not found")
+	assert.Nil(t, wrapError(nil))
+	assert.Equal(t, ErrRecordNotFound, wrapError(mgo.ErrNotFound), "Expected not found to stay comparable")
+
+	t.Log("When a unique index is violated")
+	lastErr := &mgo.LastError{Code: 11000, Err: `E11000 duplicate key error collection: test.users index: email_1 dup key: { email: "user@example.com" }`}
+	err := wrapError(lastErr)
+	assert.True(t, errors.Is(err, ErrDuplicateKey))
+	var dupErr *DuplicateKeyError
+	assert.True(t, errors.As(err, &dupErr))
+	assert.Equal(t, "email_1", dupErr.Index)
+	assert.Equal(t, `{ email: "user@example.com" }`, dupErr.Key)
+	assert.Equal(t, lastErr, errors.Unwrap(err), "Expected the mgo error to be wrapped")
+	assert.Equal(t, lastErr.Error(), err.Error())
+
+	t.Log("When an older server reports the duplicate key")
+	err = wrapError(&mgo.QueryError{Code: 11000, Message: `E11000 duplicate key error index: test.users.$email_1  dup key: { : "user@example.com" }`})
+	assert.True(t, errors.As(err, &dupErr))
+	assert.Equal(t, "email_1", dupErr.Index)
+	assert.Equal(t, `{ : "user@example.com" }`, dupErr.Key)
+
+	t.Log("When the operation times out")
+	assert.True(t, errors.Is(wrapError(timeoutNetError{}), ErrTimeout), "Expected network timeouts")
+	assert.True(t, errors.Is(wrapError(&mgo.QueryError{Code: 50, Message: "operation exceeded time limit"}), ErrTimeout), "Expected max time exceeded")
+	assert.True(t, errors.Is(wrapError(&mgo.LastError{WTimeout: true, Err: "waiting for replication timed out"}), ErrTimeout), "Expected write concern timeouts")
+	assert.False(t, errors.Is(wrapError(timeoutNetError{}), ErrConnection))
+
+	t.Log("When the servers cannot be reached")
+	for _, connErr := range []error{
+		errors.New("no reachable servers"),
+		io.EOF,
+		&net.OpError{Op: "dial", Err: errors.New("connection refused")},
+		&mgo.QueryError{Code: 6, Message: "host unreachable"},
+	} {
+		err = wrapError(connErr)
+		assert.True(t, errors.Is(err, ErrConnection), "Expected connection error for "+connErr.Error())
+		assert.Equal(t, connErr, errors.Unwrap(err))
+	}
+
+	t.Log("When a document fails validation")
+	assert.True(t, errors.Is(wrapError(&mgo.LastError{Code: 121, Err: "Document failed validation"}), ErrValidation))
+
+	t.Log("When the error is already typed or unknown")
+	decryptionErr := &DecryptionError{Field: "ssn", Err: ErrCiphertextMismatch}
+	assert.Equal(t, decryptionErr, wrapError(decryptionErr))
+	assert.Equal(t, ErrUnknownCipher, wrapError(ErrUnknownCipher))
+}
+
+func TestDecryptionError(t *testing.T) {
+	err := error(&DecryptionError{Field: "ssn", Err: ErrCiphertextMismatch})
+	assert.Equal(t, "cannot decrypt field ssn: encrypted value does not belong to this document and field", err.Error())
+	assert.True(t, errors.Is(err, ErrDecryption))
+	assert.True(t, errors.Is(err, ErrCiphertextMismatch))
+	assert.False(t, errors.Is(err, ErrDuplicateKey))
+}
+
+func Test_hasErrorCode(t *testing.T) {
+	assert.False(t, hasErrorCode(nil, 26), "Expected no code without an error")
+	assert.True(t, hasErrorCode(&mgo.QueryError{Code: 26, Message: "ns not found"}, 26))
+	assert.False(t, hasErrorCode(&mgo.QueryError{Code: 48, Message: "collection already exists"}, 26))
+	assert.False(t, hasErrorCode(errors.New("no reachable servers"), 26))
+}
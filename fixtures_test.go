@@ -29,6 +29,9 @@ type mockModel struct {
 	PlainTextField  string        `json:"plain_text_field" bson:"plain_text_field"`
 	NumField        int           `json:"num_field" bson:"num_field" encrypt:"aes"`
 	EncryptedField2 string        `json:"encrypted_field2" bson:"encrypted_field2" encrypt:"invalid_type"`
+	SecretField     Secret        `json:"secret_field" bson:"secret_field"`
+	BcryptField     string        `json:"-" bson:"bcrypt_field" hash:"bcrypt"`
+	Argon2idField   string        `json:"-" bson:"argon2id_field" hash:"argon2id"`
 }
 
 type mockModels []mockModel
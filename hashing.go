@@ -0,0 +1,89 @@
+package mgostore
+
+import (
+	"reflect"
+
+	"github.com/gsingharoy/mgostore/lib"
+)
+
+/*
+hashFields replaces the values of the fields tagged with `hash:"bcrypt"` or `hash:"argon2id"`
+with a one way hash of them.
+stored is the model as stored in the DB, or nil for a new model. A value which equals the stored
+value is the stored hash and left as it is, so a field is only hashed again when it has been changed.
+*/
+func hashFields(m Model, stored Model) error {
+	s := reflect.ValueOf(m).Elem()
+	t := reflect.TypeOf(m).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		vField := s.Field(i)
+		algorithm := f.Tag.Get("hash")
+		if algorithm == "" || f.Type != reflect.TypeOf("") || len(vField.String()) == 0 {
+			continue
+		}
+		if stored != nil && vField.String() == reflect.ValueOf(stored).Elem().Field(i).String() {
+			continue
+		}
+		var hashedValue string
+		var err error
+		switch algorithm {
+		case "bcrypt":
+			hashedValue, err = lib.BcryptHash(vField.String())
+		case "argon2id":
+			hashedValue, err = lib.Argon2idHash(vField.String())
+		default:
+			return ErrUnknownHashAlgorithm
+		}
+		if err != nil {
+			return err
+		}
+		vField.SetString(hashedValue)
+	}
+	return nil
+}
+
+// hasHashedFields reports if the model has fields tagged with hash
+func hasHashedFields(m Model) bool {
+	t := reflect.TypeOf(m).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("hash") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Verify compares a candidate value against the hash stored in a hashed field of the model.
+fieldName is the name of the struct field, eg. "Password".
+Returns nil when the candidate matches and ErrHashMismatch when it does not.
+
+	user := &User{ID: oId}
+	mgostore.Find(user)
+	if err := mgostore.Verify(user, "Password", "candidate password"); err != nil {
+		// wrong password
+	}
+*/
+func Verify(m Model, fieldName string, candidate string) error {
+	f, ok := reflect.TypeOf(m).Elem().FieldByName(fieldName)
+	if !ok || f.Type != reflect.TypeOf("") {
+		return ErrFieldNotHashed
+	}
+	hash := reflect.ValueOf(m).Elem().FieldByName(fieldName).String()
+	var err error
+	switch f.Tag.Get("hash") {
+	case "bcrypt":
+		err = lib.BcryptVerify(hash, candidate)
+	case "argon2id":
+		err = lib.Argon2idVerify(hash, candidate)
+	case "":
+		return ErrFieldNotHashed
+	default:
+		return ErrUnknownHashAlgorithm
+	}
+	if err == lib.ErrHashMismatch {
+		return ErrHashMismatch
+	}
+	return err
+}
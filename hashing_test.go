@@ -0,0 +1,62 @@
+package mgostore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_hashFields(t *testing.T) {
+	m := &mockModel{
+		PlainTextField: "plain text",
+		BcryptField:    "bcrypt password",
+		Argon2idField:  "argon2id password"}
+	err := hashFields(m, nil)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, "plain text", m.PlainTextField, "Expected plain text field to not be hashed")
+	assert.NotEqual(t, "bcrypt password", m.BcryptField, "Expected bcrypt field to be hashed")
+	assert.NotEqual(t, "argon2id password", m.Argon2idField, "Expected argon2id field to be hashed")
+
+	t.Log("When the hashed fields have not changed")
+	stored := &mockModel{BcryptField: m.BcryptField, Argon2idField: m.Argon2idField}
+	bcryptHash, argon2idHash := m.BcryptField, m.Argon2idField
+	hashFields(m, stored)
+	assert.Equal(t, bcryptHash, m.BcryptField, "Expected bcrypt hash to not be hashed again")
+	assert.Equal(t, argon2idHash, m.Argon2idField, "Expected argon2id hash to not be hashed again")
+
+	t.Log("When a changed value looks like a hash")
+	m.Argon2idField = "$argon2id$v=19$m=1,t=0,p=0$$"
+	hashFields(m, stored)
+	assert.NotEqual(t, "$argon2id$v=19$m=1,t=0,p=0$$", m.Argon2idField, "Expected the value to be hashed")
+	assert.Nil(t, Verify(m, "Argon2idField", "$argon2id$v=19$m=1,t=0,p=0$$"))
+
+	t.Log("When a new model has a value which looks like a hash")
+	n := &mockModel{BcryptField: bcryptHash}
+	hashFields(n, nil)
+	assert.NotEqual(t, bcryptHash, n.BcryptField, "Expected the value to be hashed")
+
+	t.Log("When the algorithm is unknown")
+	type unknownHashModel struct {
+		mockModel
+		Field string `hash:"md5"`
+	}
+	u := &unknownHashModel{Field: "password"}
+	assert.Equal(t, ErrUnknownHashAlgorithm, hashFields(u, nil))
+}
+
+func TestVerify(t *testing.T) {
+	m := &mockModel{BcryptField: "bcrypt password", Argon2idField: "argon2id password"}
+	hashFields(m, nil)
+
+	t.Log("When the candidate matches")
+	assert.Nil(t, Verify(m, "BcryptField", "bcrypt password"))
+	assert.Nil(t, Verify(m, "Argon2idField", "argon2id password"))
+
+	t.Log("When the candidate does not match")
+	assert.Equal(t, ErrHashMismatch, Verify(m, "BcryptField", "argon2id password"))
+	assert.Equal(t, ErrHashMismatch, Verify(m, "Argon2idField", "bcrypt password"))
+
+	t.Log("When the field is not hashed")
+	assert.Equal(t, ErrFieldNotHashed, Verify(m, "PlainTextField", "plain text"))
+	assert.Equal(t, ErrFieldNotHashed, Verify(m, "UnknownField", "plain text"))
+}
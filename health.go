@@ -0,0 +1,128 @@
+package mgostore
+
+import (
+	"sort"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// default pool limit of mgo sessions
+const defaultPoolLimit = 4096
+
+/*
+ConnectionStats represents the state of one connection held in the registry
+*/
+type ConnectionStats struct {
+	// Servers of the connection, without credentials
+	Servers string
+	// Servers which are currently reachable
+	LiveServers []string
+	// Maximum number of sockets in use per server
+	PoolLimit int
+	// Last connection or authentication error of a Ping or an operation, if any, and when it happened
+	LastError     error
+	LastErrorTime time.Time
+}
+
+/*
+Stats represents the state of the connections of mgostore.
+*/
+type Stats struct {
+	Connections []ConnectionStats
+	// Global counts of the sockets of all mgo sessions of the process, not of any single
+	// connection. They include sessions which were not dialled by mgostore, since mgo does not
+	// count sockets per connection. They are only counted after EnableSocketStats has been called
+	ProcessSocketsAlive int
+	ProcessSocketsInUse int
+}
+
+/*
+Ping checks whether the cluster of the config is reachable, dialing it if needed.
+It is meant for readiness probes.
+*/
+func Ping(config *MongoConfig) error {
+	e, session, err := newEntrySession(config)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err = session.Ping(); err != nil {
+		e.setLastError(err)
+		return err
+	}
+	return nil
+}
+
+/*
+EnableSocketStats makes mgo count the sockets reported by FetchStats.
+Counting has a small overhead, so it is disabled by default.
+*/
+func EnableSocketStats() {
+	socketStatsMux.Lock()
+	defer socketStatsMux.Unlock()
+	mgo.SetStats(true)
+	socketStatsEnabled = true
+}
+
+/*
+FetchStats returns the state of all connections in the registry, ordered by their servers.
+Connections which are still dialing or were closed meanwhile are left out.
+The socket counts are global to the process, see Stats.
+*/
+func FetchStats() *Stats {
+	registryMux.Lock()
+	entries := make([]*registryEntry, 0, len(registry))
+	for _, e := range registry {
+		entries = append(entries, e)
+	}
+	registryMux.Unlock()
+
+	stats := &Stats{}
+	for _, e := range entries {
+		select {
+		case <-e.ready:
+		default:
+			continue
+		}
+		if cs, ok := e.stats(); ok {
+			stats.Connections = append(stats.Connections, cs)
+		}
+	}
+	sort.Slice(stats.Connections, func(i, j int) bool {
+		return stats.Connections[i].Servers < stats.Connections[j].Servers
+	})
+
+	// mgo panics when its stats are read without being enabled
+	socketStatsMux.Lock()
+	defer socketStatsMux.Unlock()
+	if socketStatsEnabled {
+		mgoStats := mgo.GetStats()
+		stats.ProcessSocketsAlive = mgoStats.SocketsAlive
+		stats.ProcessSocketsInUse = mgoStats.SocketsInUse
+	}
+	return stats
+}
+
+// stats returns the state of a dialed entry, or false when it has no open session
+func (e *registryEntry) stats() (ConnectionStats, bool) {
+	e.sessionMux.RLock()
+	if e.session == nil || e.closed {
+		e.sessionMux.RUnlock()
+		return ConnectionStats{}, false
+	}
+	cs := ConnectionStats{
+		Servers:     e.servers,
+		LiveServers: e.session.LiveServers(),
+		PoolLimit:   e.poolLimit,
+	}
+	e.sessionMux.RUnlock()
+	if cs.PoolLimit <= 0 {
+		cs.PoolLimit = defaultPoolLimit
+	}
+	e.mux.Lock()
+	cs.LastError = e.lastErr
+	cs.LastErrorTime = e.lastErrTime
+	e.mux.Unlock()
+	return cs, true
+}
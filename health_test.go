@@ -0,0 +1,62 @@
+package mgostore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func TestPing(t *testing.T) {
+	setTestEnvVars()
+
+	t.Log("When the server is not reachable")
+	os.Setenv("MONGODB_SERVERS", "invalid_server")
+	err := Ping(testMongoConfig())
+	assert.Equal(t,
+		"no reachable servers",
+		err.Error(),
+		"Expected not reachable servers error")
+
+	t.Log("When the server is reachable")
+	setTestEnvVars()
+	err = Ping(testMongoConfig())
+	assert.Nil(t, err, "Expected no error")
+
+	stats := FetchStats()
+	assert.Equal(t, 1, len(stats.Connections), "Expected the connection in the stats")
+	assert.Equal(t, "localhost", stats.Connections[0].Servers)
+	assert.NotEmpty(t, stats.Connections[0].LiveServers, "Expected live servers")
+	assert.Equal(t, defaultPoolLimit, stats.Connections[0].PoolLimit)
+}
+
+func TestFetchStats(t *testing.T) {
+	CloseAll()
+	dialing := &registryEntry{servers: "dialing_server", ready: make(chan struct{})}
+	failed := &registryEntry{servers: "failed_server", ready: make(chan struct{})}
+	close(failed.ready)
+	registryMux.Lock()
+	registry["dialing"] = dialing
+	registry["failed"] = failed
+	registryMux.Unlock()
+	defer func() {
+		close(dialing.ready)
+		CloseAll()
+	}()
+
+	stats := FetchStats()
+	assert.Equal(t, 0, len(stats.Connections), "Expected connections without a session to be left out")
+	assert.Equal(t, 0, stats.ProcessSocketsAlive, "Expected sockets to not be counted")
+
+	t.Log("When socket stats are enabled")
+	EnableSocketStats()
+	defer func() {
+		socketStatsMux.Lock()
+		mgo.SetStats(false)
+		socketStatsEnabled = false
+		socketStatsMux.Unlock()
+	}()
+	stats = FetchStats()
+	assert.Equal(t, 0, stats.ProcessSocketsInUse, "Expected no sockets in use")
+}
@@ -1,7 +1,9 @@
 package mgostore
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 
 	"gopkg.in/mgo.v2/bson"
 )
@@ -16,3 +18,49 @@ func generateModelID(m Model) {
 	id := bson.NewObjectId()
 	f.Set(reflect.ValueOf(id))
 }
+
+// setModelIDVal sets the ID of the model
+func setModelIDVal(m Model, id interface{}) {
+	reflect.ValueOf(m).Elem().FieldByName("ID").Set(reflect.ValueOf(id))
+}
+
+/*
+copyModel returns a shallow copy of the model.
+Values are hashed and encrypted on the copy, so that the model of the caller is left
+as it is when storing it fails and the call can be retried.
+*/
+func copyModel(m Model) Model {
+	c := reflect.New(reflect.TypeOf(m).Elem())
+	c.Elem().Set(reflect.ValueOf(m).Elem())
+	return c.Interface().(Model)
+}
+
+// modelIDString returns the ID of the model in a stable string form
+func modelIDString(m Model) string {
+	return idString(fetchModelIDVal(m))
+}
+
+// idString returns a document ID in a stable string form
+func idString(id interface{}) string {
+	if oID, ok := id.(bson.ObjectId); ok {
+		return oID.Hex()
+	}
+	return fmt.Sprint(id)
+}
+
+// fieldBSONName returns the key with which the field is stored in mongo.
+// Like mgo, it falls back to the lower cased field name when there is no bson tag.
+func fieldBSONName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("bson"), ",")[0]
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// resetModel sets all fields of the model to their zero values, except for the ID
+func resetModel(m Model, id interface{}) {
+	v := reflect.ValueOf(m).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	setModelIDVal(m, id)
+}
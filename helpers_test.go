@@ -1,6 +1,7 @@
 package mgostore
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,3 +19,13 @@ func TestGenerateModelId(t *testing.T) {
 	generateModelID(m)
 	assert.NotNil(t, m.ID)
 }
+
+func TestFieldBSONName(t *testing.T) {
+	type s struct {
+		Tagged   string `bson:"tagged_field,omitempty"`
+		Untagged string
+	}
+	st := reflect.TypeOf(s{})
+	assert.Equal(t, "tagged_field", fieldBSONName(st.Field(0)), "Expected the bson tag name")
+	assert.Equal(t, "untagged", fieldBSONName(st.Field(1)), "Expected the lower cased field name")
+}
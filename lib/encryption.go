@@ -7,6 +7,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // encrypt string to base64 crypto using AES
@@ -36,12 +38,15 @@ func AesEncrypt(key []byte, text string) (string, error) {
 
 // decrypt from base64 to decrypted string
 func AesDecrypt(key []byte, cryptoText string) (string, error) {
-	ciphertext, _ := base64.URLEncoding.DecodeString(cryptoText)
+	ciphertext, decodeErr := base64.URLEncoding.DecodeString(cryptoText)
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
+	if decodeErr != nil {
+		return "", decodeErr
+	}
 
 	// The IV needs to be unique, but not secure. Therefore it's common to
 	// include it at the beginning of the ciphertext.
@@ -58,3 +63,82 @@ func AesDecrypt(key []byte, cryptoText string) (string, error) {
 
 	return fmt.Sprintf("%s", ciphertext), nil
 }
+
+// encrypt string to base64 crypto using AES-GCM.
+// additionalData is authenticated along with the ciphertext but is not stored in it,
+// so the same additionalData has to be passed back to AesGcmDecrypt.
+func AesGcmEncrypt(key []byte, text string, additionalData []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	return aeadEncrypt(gcm, text, additionalData)
+}
+
+// decrypt from base64 AES-GCM crypto to decrypted string.
+// Returns ErrAuthenticationFailed when the ciphertext was tampered with
+// or was not encrypted with the same key and additionalData.
+func AesGcmDecrypt(key []byte, cryptoText string, additionalData []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	return aeadDecrypt(gcm, cryptoText, additionalData)
+}
+
+// encrypt string to base64 crypto using ChaCha20-Poly1305.
+// The key has to be 32 bytes long. additionalData is handled as in AesGcmEncrypt.
+func ChaCha20Poly1305Encrypt(key []byte, text string, additionalData []byte) (string, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return "", err
+	}
+	return aeadEncrypt(aead, text, additionalData)
+}
+
+// decrypt from base64 ChaCha20-Poly1305 crypto to decrypted string.
+// Returns ErrAuthenticationFailed as AesGcmDecrypt does.
+func ChaCha20Poly1305Decrypt(key []byte, cryptoText string, additionalData []byte) (string, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return "", err
+	}
+	return aeadDecrypt(aead, cryptoText, additionalData)
+}
+
+func aeadEncrypt(aead cipher.AEAD, text string, additionalData []byte) (string, error) {
+	// The nonce has to be unique for every encryption with the same key.
+	// It is stored at the beginning of the ciphertext.
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	ciphertext := aead.Seal(nonce, nonce, []byte(text), additionalData)
+
+	// convert to base64
+	return base64.URLEncoding.EncodeToString(ciphertext), nil
+}
+
+func aeadDecrypt(aead cipher.AEAD, cryptoText string, additionalData []byte) (string, error) {
+	ciphertext, err := base64.URLEncoding.DecodeString(cryptoText)
+	if err != nil {
+		return "", err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return "", ErrCiphertextShort
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, ciphertext[aead.NonceSize():], additionalData)
+	if err != nil {
+		return "", ErrAuthenticationFailed
+	}
+	return string(plaintext), nil
+}
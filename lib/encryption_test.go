@@ -39,4 +39,70 @@ func TestAesDecrypt(t *testing.T) {
 	decryptedText, err = AesDecrypt(key, encryptedText)
 	assert.Equal(t, err, nil, "Expected error to be nil")
 	assert.Equal(t, "Sample text", decryptedText, "Decryption does not match original plain text")
+
+	t.Log("When the text is not base64")
+	_, err = AesDecrypt(key, "not base64!")
+	assert.NotNil(t, err, "Expected the decoding error")
+}
+
+func TestAesGcmEncrypt(t *testing.T) {
+	key := []byte("INVALID AES KEY")
+	t.Log("When an invalid AES key is sent")
+	encryptedText, err := AesGcmEncrypt(key, "Sample text", []byte("aad"))
+	assert.Equal(t, "", encryptedText, "Encrypted text is not empty")
+	assert.Equal(t, aes.KeySizeError(15), err, "Expected invalid key size error")
+
+	t.Log("When the AES key is a valid one")
+	key = []byte(testAesKey)
+	encryptedText, err = AesGcmEncrypt(key, "Sample text", []byte("aad"))
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.NotEqual(t, "", encryptedText, "Text is encrypted successfully")
+
+	t.Log("When the same text is encrypted twice")
+	otherText, _ := AesGcmEncrypt(key, "Sample text", []byte("aad"))
+	assert.NotEqual(t, encryptedText, otherText, "Expected a unique nonce per encryption")
+}
+
+func TestAesGcmDecrypt(t *testing.T) {
+	key := []byte("INVALID AES KEY")
+	t.Log("When an invalid AES key is sent")
+	decryptedText, err := AesGcmDecrypt(key, "U2FtcGxlIHRleHQ=", nil)
+	assert.Equal(t, aes.KeySizeError(15), err, "Expected invalid key size error")
+	assert.Equal(t, "", decryptedText, "Decrypted text is empty")
+
+	key = []byte(testAesKey)
+	t.Log("When the ciphertext is too short")
+	_, err = AesGcmDecrypt(key, "U2FtcGxlIHRleHQ=", nil)
+	assert.Equal(t, ErrCiphertextShort, err)
+
+	t.Log("When the additional data matches")
+	encryptedText, _ := AesGcmEncrypt(key, "Sample text", []byte("doc1/field"))
+	decryptedText, err = AesGcmDecrypt(key, encryptedText, []byte("doc1/field"))
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.Equal(t, "Sample text", decryptedText, "Decryption does not match original plain text")
+
+	t.Log("When the additional data does not match")
+	decryptedText, err = AesGcmDecrypt(key, encryptedText, []byte("doc2/field"))
+	assert.Equal(t, ErrAuthenticationFailed, err, "Expected authentication error")
+	assert.Equal(t, "", decryptedText, "Decrypted text is empty")
+}
+
+func TestChaCha20Poly1305(t *testing.T) {
+	key := []byte("INVALID KEY")
+	t.Log("When an invalid key is sent")
+	encryptedText, err := ChaCha20Poly1305Encrypt(key, "Sample text", nil)
+	assert.Equal(t, "", encryptedText, "Encrypted text is not empty")
+	assert.NotNil(t, err, "Expected invalid key size error")
+
+	t.Log("When the key is a valid one")
+	key = []byte(testAesKey)
+	encryptedText, err = ChaCha20Poly1305Encrypt(key, "Sample text", []byte("aad"))
+	assert.Nil(t, err, "Expected error to be nil")
+	decryptedText, err := ChaCha20Poly1305Decrypt(key, encryptedText, []byte("aad"))
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.Equal(t, "Sample text", decryptedText, "Decryption does not match original plain text")
+
+	t.Log("When the additional data does not match")
+	_, err = ChaCha20Poly1305Decrypt(key, encryptedText, []byte("other aad"))
+	assert.Equal(t, ErrAuthenticationFailed, err, "Expected authentication error")
 }
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters used for argon2id hashes. They are encoded in every hash,
+// so changing them does not invalidate hashes which are already stored.
+const (
+	argon2Time    uint32 = 1
+	argon2Memory  uint32 = 64 * 1024
+	argon2Threads uint8  = 4
+	argon2KeyLen  uint32 = 32
+	argon2SaltLen        = 16
+)
+
+// hash the text with bcrypt using the default cost
+func BcryptHash(text string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(text), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// compare a bcrypt hash with a candidate text
+func BcryptVerify(hash, candidate string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(candidate))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrHashMismatch
+	}
+	return err
+}
+
+// hash the text with argon2id and encode it along with its parameters as
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+func Argon2idHash(text string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(text), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		argon2Memory,
+		argon2Time,
+		argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// compare an encoded argon2id hash with a candidate text
+func Argon2idVerify(hash, candidate string) error {
+	var memory, time uint32
+	var threads uint8
+	var version int
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return ErrInvalidHash
+	}
+	// argon2 panics on these parameters
+	if memory == 0 || time < 1 || threads < 1 {
+		return ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		// An empty key would match every candidate
+		return ErrInvalidHash
+	}
+	candidateKey := argon2.IDKey([]byte(candidate), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, candidateKey) != 1 {
+		return ErrHashMismatch
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBcryptHash(t *testing.T) {
+	hash, err := BcryptHash("my password")
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.NotEqual(t, "my password", hash, "Expected text to be hashed")
+
+	t.Log("When the candidate matches")
+	assert.Nil(t, BcryptVerify(hash, "my password"))
+
+	t.Log("When the candidate does not match")
+	assert.Equal(t, ErrHashMismatch, BcryptVerify(hash, "not my password"))
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hash, err := Argon2idHash("my password")
+	assert.Nil(t, err, "Expected error to be nil")
+
+	otherHash, _ := Argon2idHash("my password")
+	assert.NotEqual(t, hash, otherHash, "Expected a unique salt per hash")
+
+	t.Log("When the candidate matches")
+	assert.Nil(t, Argon2idVerify(hash, "my password"))
+
+	t.Log("When the candidate does not match")
+	assert.Equal(t, ErrHashMismatch, Argon2idVerify(hash, "not my password"))
+
+	t.Log("When the hash is malformed")
+	assert.Equal(t, ErrInvalidHash, Argon2idVerify("$argon2id$v=19$broken", "my password"))
+
+	t.Log("When the parameters of the hash are out of range")
+	assert.Equal(t, ErrInvalidHash, Argon2idVerify("$argon2id$v=19$m=1,t=0,p=0$$", "my password"))
+	assert.Equal(t, ErrInvalidHash, Argon2idVerify("$argon2id$v=19$m=65536,t=1,p=0$c2FsdA$a2V5", "my password"))
+	assert.Equal(t, ErrInvalidHash, Argon2idVerify("$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5", "my password"))
+
+	t.Log("When the key of the hash is empty")
+	assert.Equal(t, ErrInvalidHash, Argon2idVerify("$argon2id$v=19$m=8,t=1,p=1$c2FsdA$", "my password"))
+}
@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+/*
+Streaming AES-GCM for contents too large to be encrypted in one piece.
+The plaintext is split into chunks which are sealed one by one (the STREAM construction):
+the nonce of each chunk is a random prefix of the stream, the number of the chunk and
+a flag marking the last chunk. Chunks can therefore neither be reordered nor dropped,
+and a truncated stream fails to decrypt.
+
+	version (1 byte) | nonce prefix (7 bytes) | sealed chunk | sealed chunk | ... | sealed last chunk
+*/
+
+const (
+	streamVersion     = 1
+	streamPrefixSize  = 7
+	streamHeaderSize  = 1 + streamPrefixSize
+	StreamChunkSize   = 64 * 1024
+	streamMaxChunkIdx = 1<<32 - 1
+)
+
+type gcmStreamWriter struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	prefix  []byte
+	ad      []byte
+	counter uint64
+	buf     []byte
+	closed  bool
+}
+
+/*
+NewAesGcmStreamWriter returns a writer which encrypts everything written to it into w.
+Close must be called to write the last chunk, it does not close w.
+additionalData is authenticated with every chunk, like with AesGcmEncrypt.
+*/
+func NewAesGcmStreamWriter(key []byte, w io.Writer, additionalData []byte) (io.WriteCloser, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	header[0] = streamVersion
+	if _, err = io.ReadFull(rand.Reader, header[1:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &gcmStreamWriter{
+		aead:   aead,
+		w:      w,
+		prefix: header[1:],
+		ad:     additionalData,
+		buf:    make([]byte, 0, StreamChunkSize),
+	}, nil
+}
+
+func (sw *gcmStreamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+		// A full chunk is never the last one, Close seals the rest
+		if len(sw.buf) == cap(sw.buf) {
+			if err := sw.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals the last chunk, which is shorter than StreamChunkSize and may be empty
+func (sw *gcmStreamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.seal(true)
+}
+
+func (sw *gcmStreamWriter) seal(last bool) error {
+	if sw.counter > streamMaxChunkIdx {
+		return ErrStreamTooLong
+	}
+	sealed := sw.aead.Seal(nil, streamNonce(sw.prefix, sw.counter, last), sw.buf, sw.ad)
+	sw.counter++
+	sw.buf = sw.buf[:0]
+	_, err := sw.w.Write(sealed)
+	return err
+}
+
+type gcmStreamReader struct {
+	aead    cipher.AEAD
+	r       io.Reader
+	prefix  []byte
+	ad      []byte
+	counter uint64
+	sealed  []byte
+	plain   []byte
+	done    bool
+}
+
+/*
+NewAesGcmStreamReader returns a reader which decrypts a stream written by NewAesGcmStreamWriter.
+Read returns ErrAuthenticationFailed when the stream was tampered with or truncated.
+*/
+func NewAesGcmStreamReader(key []byte, r io.Reader, additionalData []byte) (io.Reader, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrCiphertextShort
+		}
+		return nil, err
+	}
+	if header[0] != streamVersion {
+		return nil, ErrUnknownStreamVersion
+	}
+	return &gcmStreamReader{
+		aead:   aead,
+		r:      r,
+		prefix: header[1:],
+		ad:     additionalData,
+		sealed: make([]byte, StreamChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (sr *gcmStreamReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// open reads and opens the next chunk. Only the last chunk is shorter than a full one
+func (sr *gcmStreamReader) open() error {
+	n, err := io.ReadFull(sr.r, sr.sealed)
+	last := false
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+	if sr.counter > streamMaxChunkIdx {
+		return ErrStreamTooLong
+	}
+	plain, err := sr.aead.Open(sr.sealed[:0], streamNonce(sr.prefix, sr.counter, last), sr.sealed[:n], sr.ad)
+	if err != nil {
+		return ErrAuthenticationFailed
+	}
+	sr.counter++
+	sr.plain = plain
+	sr.done = last
+	return nil
+}
+
+// streamNonce returns the nonce of a chunk: prefix | chunk number | last flag
+func streamNonce(prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, streamPrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], uint32(counter))
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
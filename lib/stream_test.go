@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptStream(t *testing.T, key []byte, plaintext []byte, ad []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewAesGcmStreamWriter(key, &buf, ad)
+	assert.Nil(t, err)
+	// Write in odd pieces to cross chunk boundaries
+	for len(plaintext) > 0 {
+		n := 1000
+		if n > len(plaintext) {
+			n = len(plaintext)
+		}
+		w.Write(plaintext[:n])
+		plaintext = plaintext[n:]
+	}
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestAesGcmStream(t *testing.T) {
+	key := []byte(testAesKey)
+	ad := []byte("id\x00avatar")
+	for _, size := range []int{0, 10, StreamChunkSize, 2*StreamChunkSize + 5} {
+		plaintext := bytes.Repeat([]byte{'x'}, size)
+		ciphertext := encryptStream(t, key, plaintext, ad)
+
+		r, err := NewAesGcmStreamReader(key, bytes.NewReader(ciphertext), ad)
+		assert.Nil(t, err)
+		decrypted, err := ioutil.ReadAll(r)
+		assert.Nil(t, err, "Expected no error for size %d", size)
+		assert.Equal(t, plaintext, decrypted, "Expected the plaintext for size %d", size)
+	}
+
+	plaintext := bytes.Repeat([]byte{'x'}, 2*StreamChunkSize)
+	ciphertext := encryptStream(t, key, plaintext, ad)
+
+	t.Log("When the stream is truncated at a chunk boundary")
+	truncated := ciphertext[:streamHeaderSize+StreamChunkSize+16]
+	r, _ := NewAesGcmStreamReader(key, bytes.NewReader(truncated), ad)
+	_, err := ioutil.ReadAll(r)
+	assert.Equal(t, ErrAuthenticationFailed, err)
+
+	t.Log("When the additional data differs")
+	r, _ = NewAesGcmStreamReader(key, bytes.NewReader(ciphertext), []byte("other"))
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrAuthenticationFailed, err)
+
+	t.Log("When the stream is tampered with")
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	r, _ = NewAesGcmStreamReader(key, bytes.NewReader(tampered), ad)
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrAuthenticationFailed, err)
+
+	t.Log("When the stream is too short or of another version")
+	_, err = NewAesGcmStreamReader(key, bytes.NewReader(ciphertext[:3]), ad)
+	assert.Equal(t, ErrCiphertextShort, err)
+	other := append([]byte{2}, ciphertext[1:]...)
+	_, err = NewAesGcmStreamReader(key, bytes.NewReader(other), ad)
+	assert.Equal(t, ErrUnknownStreamVersion, err)
+}
@@ -5,3 +5,8 @@ import "errors"
 // All Error variables here
 
 var ErrCiphertextShort = errors.New("ciphertext too short")
+var ErrAuthenticationFailed = errors.New("ciphertext authentication failed")
+var ErrHashMismatch = errors.New("hash does not match the given value")
+var ErrInvalidHash = errors.New("invalid hash format")
+var ErrUnknownStreamVersion = errors.New("unknown version of encrypted stream")
+var ErrStreamTooLong = errors.New("encrypted stream has too many chunks")
@@ -0,0 +1,359 @@
+package mgostore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultReencryptBatchSize = 100
+	// Number of times a document which was changed concurrently is read and re-encrypted again
+	maxReencryptAttempts = 3
+)
+
+// errUndecryptable marks a document which is reported instead of failing the run
+var errUndecryptable = errors.New("document cannot be decrypted")
+
+// ReencryptMode is what a run of Reencrypt or ReencryptCollection does with the values of the encrypted fields
+type ReencryptMode string
+
+const (
+	// ReencryptValues decrypts the values with From and encrypts them again with To
+	ReencryptValues ReencryptMode = ""
+	// EncryptPlaintext encrypts values stored in plaintext with To, eg. after encrypt tags were added.
+	// Values which already decrypt with To are left as they are
+	EncryptPlaintext ReencryptMode = "encrypt"
+	// DecryptToPlaintext decrypts the values with From and stores them in plaintext, eg. before encrypt tags are removed
+	DecryptToPlaintext ReencryptMode = "decrypt"
+)
+
+/*
+ReencryptOptions configures a run of Reencrypt or ReencryptCollection.
+*/
+type ReencryptOptions struct {
+	// What is done with the values, re-encrypting them by default
+	Mode ReencryptMode
+	// Keys the documents are currently encrypted with, not needed by EncryptPlaintext
+	From *CryptoConfig
+	// Keys the documents are re-encrypted with. Defaults to From, which is useful to change ciphers only
+	To *CryptoConfig
+	// Cipher the documents are currently encrypted with, eg. "aes-cfb" to migrate legacy values.
+	// When empty the cipher of each field is used for decrypting as well.
+	FromCipher string
+	// Allows decrypting with ciphers which do not authenticate the values, like "aes-cfb" or
+	// "aes" with From.LegacyCFB. A wrong key yields garbage instead of an error with them, which
+	// would be written back. Values which do not decrypt to valid UTF-8 are reported as undecryptable,
+	// which catches most wrong keys but not all of them, so do a DryRun first
+	AllowUnauthenticated bool
+	// Number of documents read and written per batch. Defaults to 100
+	BatchSize int
+	// Pause after every batch to throttle the load on the DB
+	Throttle time.Duration
+	// Only reports the documents which cannot be decrypted, nothing is written to the DB or the Checkpoint
+	DryRun bool
+	// Stores the progress so that an interrupted run resumes after the last processed document
+	Checkpoint Checkpoint
+	// Cache of the documents of the collection, re-encrypted documents are invalidated in it.
+	// Reencrypt defaults it to the cache of a CachedModel
+	Cache Cache
+}
+
+/*
+ReencryptReport summarises a run of Reencrypt or ReencryptCollection.
+*/
+type ReencryptReport struct {
+	// Number of documents which were read
+	Processed int
+	// Number of documents which were written back, re-encrypted, encrypted or decrypted according to the mode
+	Reencrypted int
+	// IDs of the documents which could not be decrypted with the From config. They are left unchanged
+	Undecryptable []interface{}
+	// IDs of the documents which kept being changed by other writers while they were re-encrypted.
+	// They are left unchanged, run again to re-encrypt them
+	Conflicts []interface{}
+	// ID of the last processed document
+	LastID interface{}
+}
+
+/*
+Checkpoint stores the ID of the last document processed by a maintenance run.
+*/
+type Checkpoint interface {
+	// Load returns the last saved ID, or nil when the run should start from the beginning
+	Load() (interface{}, error)
+	Save(lastID interface{}) error
+}
+
+/*
+FileCheckpoint is a Checkpoint which stores the last ID in a file.
+The file does not need to exist before the first run.
+*/
+type FileCheckpoint struct {
+	Path string
+}
+
+type checkpointDoc struct {
+	LastID interface{} `bson:"last_id"`
+}
+
+// Load reads the last ID from the file
+func (fc *FileCheckpoint) Load() (interface{}, error) {
+	data, err := ioutil.ReadFile(fc.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc checkpointDoc
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc.LastID, nil
+}
+
+// Save writes the last ID to the file
+func (fc *FileCheckpoint) Save(lastID interface{}) error {
+	data, err := bson.Marshal(&checkpointDoc{LastID: lastID})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fc.Path, data, 0600)
+}
+
+/*
+Reencrypt rewrites every encrypted field of every document in the collection of the model.
+The documents are decrypted with opts.From and encrypted again with opts.To, unless opts.Mode
+asks to encrypt plaintext values or to decrypt them to plaintext.
+Decrypting with an unauthenticated cipher fails with ErrUnauthenticatedCipher unless opts.AllowUnauthenticated is set.
+The model is only used for its collection, DB config and encrypt tags.
+
+	report, err := mgostore.Reencrypt(&MyAwesomeModel{}, mgostore.ReencryptOptions{
+		From:       oldCryptoConfig,
+		To:         newCryptoConfig,
+		Throttle:   100 * time.Millisecond,
+		Checkpoint: &mgostore.FileCheckpoint{Path: "my_awesome_models.checkpoint"},
+	})
+*/
+func Reencrypt(m Model, opts ReencryptOptions) (*ReencryptReport, error) {
+	if opts.Cache == nil {
+		opts.Cache = modelCache(m)
+	}
+	return ReencryptCollection(m.DBConfig(), m.CollectionName(), EncryptedFieldSpecs(m), opts)
+}
+
+/*
+ReencryptCollection is Reencrypt for collections without a model, where the encrypted fields
+are described by their specs.
+Documents are processed in the order of their IDs. Documents which cannot be decrypted are
+left as they are and reported, the run carries on with the next document.
+*/
+func ReencryptCollection(config *MongoConfig, collectionName string, fields []EncryptedFieldSpec, opts ReencryptOptions) (*ReencryptReport, error) {
+	if opts.To == nil {
+		opts.To = opts.From
+	}
+	if err := checkReencryptOptions(fields, opts); err != nil {
+		return nil, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultReencryptBatchSize
+	}
+	session, err := newSession(config)
+	if session != nil {
+		defer session.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := session.DB(config.DBName).C(collectionName)
+
+	report := &ReencryptReport{}
+	if opts.Checkpoint != nil {
+		if report.LastID, err = opts.Checkpoint.Load(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		whereClause := bson.M{}
+		if report.LastID != nil {
+			whereClause["_id"] = bson.M{"$gt": report.LastID}
+		}
+		var docs []bson.M
+		if err = c.Find(whereClause).Sort("_id").Limit(opts.BatchSize).All(&docs); err != nil {
+			return report, err
+		}
+		for _, doc := range docs {
+			if err = reencryptStored(c, config.DBName, doc, fields, opts, report); err != nil {
+				return report, err
+			}
+			report.Processed++
+			report.LastID = doc["_id"]
+		}
+		if !opts.DryRun && opts.Checkpoint != nil && len(docs) > 0 {
+			if err = opts.Checkpoint.Save(report.LastID); err != nil {
+				return report, err
+			}
+		}
+		if len(docs) < opts.BatchSize {
+			return report, nil
+		}
+		time.Sleep(opts.Throttle)
+	}
+}
+
+/*
+reencryptStored re-encrypts the document and records the outcome in the report.
+The update only matches while the encrypted fields still hold the values which were read, so values
+written concurrently by other processes are not overwritten. The document is read again instead.
+*/
+func reencryptStored(c *mgo.Collection, dbName string, doc bson.M, fields []EncryptedFieldSpec, opts ReencryptOptions, report *ReencryptReport) error {
+	id := doc["_id"]
+	for attempt := 0; attempt < maxReencryptAttempts; attempt++ {
+		changes, err := reencryptDocument(doc, fields, opts)
+		switch {
+		case err == errUndecryptable:
+			report.Undecryptable = append(report.Undecryptable, id)
+			return nil
+		case err != nil:
+			return err
+		case opts.DryRun || len(changes) == 0:
+			return nil
+		}
+		selector := bson.M{"_id": id}
+		for name := range changes {
+			selector[name] = doc[name]
+		}
+		err = c.Update(selector, bson.M{"$set": changes})
+		if err != mgo.ErrNotFound {
+			if opts.Cache != nil {
+				// Invalidated even when the update failed, it might have been applied nonetheless
+				opts.Cache.Delete(collectionCacheKey(dbName, c.Name, id))
+			}
+			if err == nil {
+				report.Reencrypted++
+			}
+			return err
+		}
+		// Changed or deleted since it was read
+		doc = bson.M{}
+		if err = c.FindId(id).One(&doc); err == mgo.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	report.Conflicts = append(report.Conflicts, id)
+	return nil
+}
+
+/*
+checkReencryptOptions fails before anything is read when the keys needed by the mode are missing,
+or when a value would be decrypted by an unauthenticated cipher without AllowUnauthenticated.
+*/
+func checkReencryptOptions(fields []EncryptedFieldSpec, opts ReencryptOptions) error {
+	switch opts.Mode {
+	case ReencryptValues, DecryptToPlaintext:
+		if opts.From == nil {
+			return ErrMissingCryptoSecret
+		}
+	case EncryptPlaintext:
+		if opts.To == nil {
+			return ErrMissingCryptoSecret
+		}
+		// Values which are encrypted already can only be told apart with authenticated ciphers
+		for _, spec := range fields {
+			toCipher, err := resolveCipher(spec.Cipher, opts.To)
+			if err != nil {
+				return err
+			}
+			if !isAuthenticated(toCipher) && !opts.AllowUnauthenticated {
+				return ErrUnauthenticatedCipher
+			}
+		}
+		return nil
+	default:
+		return ErrUnknownReencryptMode
+	}
+	for _, spec := range fields {
+		fromCipher, err := resolveCipher(fromCipherName(spec, opts), opts.From)
+		if err != nil {
+			return err
+		}
+		if !isAuthenticated(fromCipher) && !opts.AllowUnauthenticated {
+			return ErrUnauthenticatedCipher
+		}
+	}
+	return nil
+}
+
+// fromCipherName returns the name of the cipher the values of the field are currently encrypted with
+func fromCipherName(spec EncryptedFieldSpec, opts ReencryptOptions) string {
+	if opts.FromCipher != "" {
+		return opts.FromCipher
+	}
+	return spec.Cipher
+}
+
+/*
+reencryptDocument returns the new values of the encrypted fields of the document, according to the mode.
+Nothing is returned unless all fields of the document could be decrypted.
+*/
+func reencryptDocument(doc bson.M, fields []EncryptedFieldSpec, opts ReencryptOptions) (bson.M, error) {
+	id := idString(doc["_id"])
+	changes := bson.M{}
+	for _, spec := range fields {
+		value, ok := doc[spec.Name].(string)
+		if !ok || len(value) == 0 {
+			continue
+		}
+		ad := associatedData(id, spec.Name)
+
+		decryptedValue := value
+		if opts.Mode != EncryptPlaintext {
+			fromCipher, err := resolveCipher(fromCipherName(spec, opts), opts.From)
+			if err != nil {
+				return nil, err
+			}
+			fromKey, err := opts.From.key(spec.Key)
+			if err != nil {
+				return nil, err
+			}
+			if decryptedValue, err = fromCipher.Decrypt(fromKey, value, ad); err != nil {
+				return nil, errUndecryptable
+			}
+			if !isAuthenticated(fromCipher) && !utf8.ValidString(decryptedValue) {
+				// Most likely decrypted with the wrong key
+				return nil, errUndecryptable
+			}
+			if opts.Mode == DecryptToPlaintext {
+				changes[spec.Name] = decryptedValue
+				continue
+			}
+		}
+
+		toCipher, err := resolveCipher(spec.Cipher, opts.To)
+		if err != nil {
+			return nil, err
+		}
+		toKey, err := opts.To.key(spec.Key)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Mode == EncryptPlaintext && isAuthenticated(toCipher) {
+			if _, err := toCipher.Decrypt(toKey, value, ad); err == nil {
+				// Encrypted already
+				continue
+			}
+		}
+		if changes[spec.Name], err = toCipher.Encrypt(toKey, decryptedValue, ad); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
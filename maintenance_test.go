@@ -0,0 +1,130 @@
+package mgostore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gsingharoy/mgostore/lib"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestEncryptedFieldSpecs(t *testing.T) {
+	specs := EncryptedFieldSpecs(&cipherModel{})
+	assert.Equal(t, []EncryptedFieldSpec{
+		{Name: "gcm_field", Cipher: "aes-gcm"},
+		{Name: "chacha_field", Cipher: "chacha20", Key: "pii"},
+		{Name: "legacy_field", Cipher: "aes-cfb"},
+		{Name: "missing_key", Cipher: "aes", Key: "unknown"},
+	}, specs)
+}
+
+func Test_reencryptDocument(t *testing.T) {
+	id := bson.NewObjectId()
+	from := &CryptoConfig{AESSecret: []byte(testEncryptionSecret)}
+	to := &CryptoConfig{AESSecret: []byte(testPIIEncryptionSecret)}
+	fields := []EncryptedFieldSpec{{Name: "encrypted_field1", Cipher: "aes"}}
+	ad := []byte(id.Hex() + "\x00encrypted_field1")
+	encryptedText, _ := aesCipher{}.Encrypt(from.AESSecret, "crypto text", ad)
+
+	t.Log("When the document can be decrypted")
+	doc := bson.M{"_id": id, "encrypted_field1": encryptedText, "plain_text_field": "plain text"}
+	changes, err := reencryptDocument(doc, fields, ReencryptOptions{From: from, To: to})
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, 1, len(changes), "Expected only the encrypted field to change")
+	decryptedText, err := aesCipher{}.Decrypt(to.AESSecret, changes["encrypted_field1"].(string), ad)
+	assert.Nil(t, err, "Expected the field to be encrypted with the new key")
+	assert.Equal(t, "crypto text", decryptedText)
+
+	t.Log("When the document was encrypted with another key")
+	changes, err = reencryptDocument(doc, fields, ReencryptOptions{From: to, To: to})
+	assert.Equal(t, errUndecryptable, err, "Expected undecryptable error")
+	assert.Nil(t, changes)
+
+	t.Log("When the cipher changes")
+	legacyText, _ := lib.AesEncrypt(from.AESSecret, "crypto text")
+	doc["encrypted_field1"] = legacyText
+	changes, err = reencryptDocument(doc, fields, ReencryptOptions{From: from, FromCipher: "aes-cfb", To: from})
+	assert.Nil(t, err, "Expected no error")
+	decryptedText, _ = aesCipher{}.Decrypt(from.AESSecret, changes["encrypted_field1"].(string), ad)
+	assert.Equal(t, "crypto text", decryptedText, "Expected the legacy value to be encrypted with the new cipher")
+
+	t.Log("When an aes field was stored with AES-CFB by an older version")
+	_, err = reencryptDocument(doc, fields, ReencryptOptions{From: from, To: from})
+	assert.Equal(t, errUndecryptable, err, "Expected legacy values to be refused by default")
+	legacy := &CryptoConfig{AESSecret: from.AESSecret, LegacyCFB: true}
+	changes, err = reencryptDocument(doc, fields, ReencryptOptions{From: legacy, To: from})
+	assert.Nil(t, err, "Expected no error")
+	assert.True(t, strings.HasPrefix(changes["encrypted_field1"].(string), aesGCMPrefix), "Expected the value to be migrated to AES-GCM")
+	decryptedText, _ = aesCipher{}.Decrypt(from.AESSecret, changes["encrypted_field1"].(string), ad)
+	assert.Equal(t, "crypto text", decryptedText)
+
+	t.Log("When an unauthenticated cipher decrypts with the wrong key")
+	legacyText, _ = lib.AesEncrypt(from.AESSecret, strings.Repeat("crypto text ", 8))
+	doc["encrypted_field1"] = legacyText
+	wrongKey := &CryptoConfig{AESSecret: to.AESSecret, LegacyCFB: true}
+	changes, err = reencryptDocument(doc, fields, ReencryptOptions{From: wrongKey, To: from, AllowUnauthenticated: true})
+	assert.Equal(t, errUndecryptable, err, "Expected the garbage to be detected")
+	assert.Nil(t, changes)
+
+	t.Log("When plaintext values are encrypted")
+	doc["encrypted_field1"] = "crypto text"
+	changes, err = reencryptDocument(doc, fields, ReencryptOptions{Mode: EncryptPlaintext, To: to})
+	assert.Nil(t, err, "Expected no error")
+	decryptedText, _ = aesCipher{}.Decrypt(to.AESSecret, changes["encrypted_field1"].(string), ad)
+	assert.Equal(t, "crypto text", decryptedText)
+	doc["encrypted_field1"] = changes["encrypted_field1"]
+	changes, err = reencryptDocument(doc, fields, ReencryptOptions{Mode: EncryptPlaintext, To: to})
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, 0, len(changes), "Expected encrypted values to be left as they are")
+
+	t.Log("When values are decrypted to plaintext")
+	changes, err = reencryptDocument(doc, fields, ReencryptOptions{Mode: DecryptToPlaintext, From: to})
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, "crypto text", changes["encrypted_field1"])
+}
+
+func Test_checkReencryptOptions(t *testing.T) {
+	config := &CryptoConfig{AESSecret: []byte(testEncryptionSecret)}
+	fields := []EncryptedFieldSpec{{Name: "encrypted_field1", Cipher: "aes"}}
+
+	t.Log("When re-encrypting without From")
+	assert.Equal(t, ErrMissingCryptoSecret, checkReencryptOptions(fields, ReencryptOptions{To: config}))
+
+	t.Log("When encrypting plaintext without From")
+	assert.Nil(t, checkReencryptOptions(fields, ReencryptOptions{Mode: EncryptPlaintext, To: config}))
+
+	t.Log("When the mode is unknown")
+	assert.Equal(t, ErrUnknownReencryptMode, checkReencryptOptions(fields, ReencryptOptions{Mode: "rotate", From: config}))
+
+	t.Log("When decrypting with an unauthenticated cipher")
+	opts := ReencryptOptions{From: config, To: config, FromCipher: "aes-cfb"}
+	assert.Equal(t, ErrUnauthenticatedCipher, checkReencryptOptions(fields, opts))
+	legacy := &CryptoConfig{AESSecret: config.AESSecret, LegacyCFB: true}
+	assert.Equal(t, ErrUnauthenticatedCipher, checkReencryptOptions(fields, ReencryptOptions{From: legacy, To: config}))
+
+	t.Log("When unauthenticated ciphers are allowed")
+	opts.AllowUnauthenticated = true
+	assert.Nil(t, checkReencryptOptions(fields, opts))
+}
+
+func TestFileCheckpoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mgostore")
+	defer os.RemoveAll(dir)
+	c := &FileCheckpoint{Path: filepath.Join(dir, "checkpoint")}
+
+	t.Log("When nothing has been saved")
+	lastID, err := c.Load()
+	assert.Nil(t, err, "Expected no error")
+	assert.Nil(t, lastID, "Expected to start from the beginning")
+
+	t.Log("When an ID has been saved")
+	id := bson.NewObjectId()
+	assert.Nil(t, c.Save(id))
+	lastID, err = c.Load()
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, id, lastID, "Expected the saved ID")
+}
@@ -0,0 +1,361 @@
+package mgostore
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+MemoryStore is a Store which keeps the documents in memory, for unit tests without a running mongod.
+Documents are stored like in mongo, with hashed and encrypted fields, and read back the same way,
+so FindMany leaves the encrypted fields encrypted just like FindManyWithOptions.
+Filters support the comparison operators, $in, $nin, $exists, $regex, $size, $all, $elemMatch,
+$not, $and, $or and $nor on dotted paths. Other operators fail with ErrUnsupportedOperator.
+*/
+type MemoryStore struct {
+	mux sync.RWMutex
+	// documents by DB and collection name, in the order they were inserted
+	collections map[string][]bson.M
+	// contents of attachments by DB, prefix and ID
+	files map[string]*memoryFile
+}
+
+var _ Store = &MemoryStore{}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: make(map[string][]bson.M), files: make(map[string]*memoryFile)}
+}
+
+// memoryCollectionKey returns the key of the collection of the model
+func memoryCollectionKey(m Model) string {
+	return m.DBConfig().DBName + "." + m.CollectionName()
+}
+
+func (s *MemoryStore) Create(m Model, opts ...Option) error {
+	// Like Create, a copy is hashed and encrypted
+	stored := copyModel(m)
+	generateModelID(stored)
+	if err := hashFields(stored, nil); err != nil {
+		return err
+	}
+	if err := encryptFields(stored); err != nil {
+		return err
+	}
+	uploaded, err := uploadAttachments(stored, s)
+	if err != nil {
+		return err
+	}
+	doc, err := normalizeDocument(stored)
+	if err != nil {
+		rollbackAttachments(uploaded)
+		return err
+	}
+	key := memoryCollectionKey(m)
+	s.mux.Lock()
+	if s.indexOf(key, doc["_id"]) >= 0 {
+		s.mux.Unlock()
+		rollbackAttachments(uploaded)
+		return wrapError(&mgo.LastError{
+			Code: 11000,
+			Err:  fmt.Sprintf("E11000 duplicate key error collection: %s index: _id_ dup key: { _id: %q }", key, idString(doc["_id"])),
+		})
+	}
+	s.collections[key] = append(s.collections[key], doc)
+	s.mux.Unlock()
+	setModelIDVal(m, fetchModelIDVal(stored))
+	return s.Find(m, opts...)
+}
+
+func (s *MemoryStore) Update(m Model, opts ...Option) error {
+	id, err := normalizeValue(fetchModelIDVal(m))
+	if err != nil {
+		return err
+	}
+	key := memoryCollectionKey(m)
+	s.mux.RLock()
+	i := s.indexOf(key, id)
+	if i < 0 {
+		s.mux.RUnlock()
+		return ErrRecordNotFound
+	}
+	stored := newModelOf(m)
+	err = decodeDocument(s.collections[key][i], stored)
+	s.mux.RUnlock()
+	if err != nil {
+		return err
+	}
+	// Like Update, a copy is hashed and encrypted
+	changed := copyModel(m)
+	if err := hashFields(changed, stored); err != nil {
+		return err
+	}
+	if err := encryptFields(changed); err != nil {
+		return err
+	}
+	uploaded, err := uploadAttachments(changed, s)
+	if err != nil {
+		return err
+	}
+	doc, err := normalizeDocument(changed)
+	if err != nil {
+		rollbackAttachments(uploaded)
+		return err
+	}
+	s.mux.Lock()
+	if i = s.indexOf(key, doc["_id"]); i < 0 {
+		// Deleted since
+		s.mux.Unlock()
+		rollbackAttachments(uploaded)
+		return ErrRecordNotFound
+	}
+	// Like $set, fields which are not in the model are kept
+	updated := bson.M{}
+	for k, v := range s.collections[key][i] {
+		updated[k] = v
+	}
+	for k, v := range doc {
+		updated[k] = v
+	}
+	s.collections[key][i] = updated
+	s.mux.Unlock()
+	bindAttachments(stored, s)
+	if err = removeAttachments(replacedAttachments(stored, m)); err != nil {
+		return err
+	}
+	return s.Find(m, opts...)
+}
+
+func (s *MemoryStore) Find(m Model, opts ...Option) error {
+	id, err := normalizeValue(fetchModelIDVal(m))
+	if err != nil {
+		return err
+	}
+	key := memoryCollectionKey(m)
+	s.mux.RLock()
+	i := s.indexOf(key, id)
+	var doc bson.M
+	if i >= 0 {
+		doc = s.collections[key][i]
+	}
+	s.mux.RUnlock()
+	if doc == nil {
+		return ErrRecordNotFound
+	}
+	if err = decodeDocument(doc, m); err != nil {
+		return err
+	}
+	bindAttachments(m, s)
+	if err = decryptFields(m); err != nil {
+		return err
+	}
+	op := newOperation(m.DBConfig(), m.CollectionName(), opts)
+	return s.preload(op, []reflect.Value{reflect.ValueOf(m)})
+}
+
+func (s *MemoryStore) FindBy(whereClause bson.M, m Model, opts ...Option) error {
+	op := newOperation(m.DBConfig(), m.CollectionName(), opts)
+	docs, err := s.query(whereClause, m, op)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return ErrRecordNotFound
+	}
+	if err = decodeDocument(docs[0], m); err != nil {
+		return err
+	}
+	bindAttachments(m, s)
+	if err = decryptFields(m); err != nil {
+		return err
+	}
+	return s.preload(op, []reflect.Value{reflect.ValueOf(m)})
+}
+
+func (s *MemoryStore) FindMany(whereClause bson.M, models Models, opts ...Option) error {
+	op := newOperation(models.DBConfig(), models.CollectionName(), opts)
+	docs, err := s.query(whereClause, models, op)
+	if err != nil {
+		return err
+	}
+	if err = decodeDocuments(applySkipLimit(docs, op), models); err != nil {
+		return err
+	}
+	bindAllAttachments(models, s)
+	return s.preload(op, modelPointers(models))
+}
+
+func (s *MemoryStore) Destroy(m Model, opts ...Option) error {
+	id, err := normalizeValue(fetchModelIDVal(m))
+	if err != nil {
+		return err
+	}
+	if err = enforceDeleteRules(m, s); err != nil {
+		return err
+	}
+	key := memoryCollectionKey(m)
+	s.mux.Lock()
+	i := s.indexOf(key, id)
+	if i < 0 {
+		s.mux.Unlock()
+		return ErrRecordNotFound
+	}
+	docs := s.collections[key]
+	stored := newModelOf(m)
+	err = decodeDocument(docs[i], stored)
+	s.collections[key] = append(docs[:i:i], docs[i+1:]...)
+	s.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	bindAttachments(stored, s)
+	return removeAttachments(storedAttachments(stored))
+}
+
+func (s *MemoryStore) Count(whereClause bson.M, m Model, opts ...Option) (int, error) {
+	op := newOperation(m.DBConfig(), m.CollectionName(), opts)
+	docs, err := s.query(whereClause, m, op)
+	if err != nil {
+		return 0, err
+	}
+	return len(applySkipLimit(docs, op)), nil
+}
+
+// indexOf returns the position of the document with the ID in the collection, or -1
+func (s *MemoryStore) indexOf(key string, id interface{}) int {
+	for i, doc := range s.collections[key] {
+		if valuesEqual(doc["_id"], id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// query returns the documents of the collection of the model which match the where clause, sorted
+func (s *MemoryStore) query(whereClause bson.M, m Model, op *operation) ([]bson.M, error) {
+	docs, err := s.queryCollection(memoryCollectionKey(m), whereClause)
+	if err != nil {
+		return nil, err
+	}
+	sortDocuments(docs, op.sort)
+	return docs, nil
+}
+
+// queryCollection returns the documents of the collection which match the where clause
+func (s *MemoryStore) queryCollection(key string, whereClause bson.M) ([]bson.M, error) {
+	filter, err := normalizeDocument(whereClause)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	s.mux.RLock()
+	for _, doc := range s.collections[key] {
+		matched, err := matchDocument(doc, filter)
+		if err != nil {
+			s.mux.RUnlock()
+			return nil, err
+		}
+		if matched {
+			docs = append(docs, doc)
+		}
+	}
+	s.mux.RUnlock()
+	return docs, nil
+}
+
+// preload loads the related models of the Preload option from the collections of the store
+func (s *MemoryStore) preload(op *operation, models []reflect.Value) error {
+	if len(op.preload) == 0 {
+		return nil
+	}
+	return preloadRelations(models, op.preload, s.loadRelated)
+}
+
+func applySkipLimit(docs []bson.M, op *operation) []bson.M {
+	if op.skip > 0 {
+		if op.skip >= len(docs) {
+			return nil
+		}
+		docs = docs[op.skip:]
+	}
+	if op.limit > 0 && op.limit < len(docs) {
+		docs = docs[:op.limit]
+	}
+	return docs
+}
+
+// decodeDocument decodes a stored document into a model, like mgo does for a query result
+func decodeDocument(doc bson.M, out interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, out)
+}
+
+// decodeDocuments decodes stored documents into out, a pointer to a slice of structs or pointers
+func decodeDocuments(docs []bson.M, out interface{}) error {
+	v := reflect.ValueOf(out).Elem()
+	elemType := v.Type().Elem()
+	slice := reflect.MakeSlice(v.Type(), 0, len(docs))
+	for _, doc := range docs {
+		if elemType.Kind() == reflect.Ptr {
+			elem := reflect.New(elemType.Elem())
+			if err := decodeDocument(doc, elem.Interface()); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem)
+			continue
+		}
+		elem := reflect.New(elemType)
+		if err := decodeDocument(doc, elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem.Elem())
+	}
+	v.Set(slice)
+	return nil
+}
+
+func (s *MemoryStore) loadRelated(m Model, collection string, filter bson.M, out interface{}) error {
+	docs, err := s.queryCollection(m.DBConfig().DBName+"."+collection, filter)
+	if err != nil {
+		return err
+	}
+	return decodeDocuments(docs, out)
+}
+
+func (s *MemoryStore) nullifyDependents(m Model, collection string, key string, id interface{}) error {
+	filter, err := normalizeDocument(bson.M{key: id})
+	if err != nil {
+		return err
+	}
+	collectionKey := m.DBConfig().DBName + "." + collection
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, doc := range s.collections[collectionKey] {
+		if matched, _ := matchDocument(doc, filter); !matched {
+			continue
+		}
+		updated := bson.M{key: nil}
+		for k, v := range doc {
+			if k != key {
+				updated[k] = v
+			}
+		}
+		s.collections[collectionKey][i] = updated
+	}
+	return nil
+}
+
+func (s *MemoryStore) destroyDependent(m Model) error {
+	err := s.Destroy(m)
+	if err == ErrRecordNotFound {
+		// Destroyed by another cascade
+		return nil
+	}
+	return err
+}
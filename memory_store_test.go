@@ -0,0 +1,88 @@
+package mgostore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMemoryStore(t *testing.T) {
+	setTestEnvVars()
+	var s Store = NewMemoryStore()
+
+	t.Log("When a model is created")
+	m := &mockModel{EncryptedField1: "crypto text", PlainTextField: "plain text", NumField: 1, BcryptField: "password"}
+	assert.Nil(t, s.Create(m))
+	assert.NotEqual(t, "", string(m.ID), "Expected object Id to be generated")
+	assert.Equal(t, "crypto text", m.EncryptedField1, "Expected encrypted field to be plain text")
+	assert.Nil(t, Verify(m, "BcryptField", "password"), "Expected the field to be hashed")
+
+	doc := s.(*MemoryStore).collections["mgostore_test.mock_models"][0]
+	assert.NotEqual(t, "crypto text", doc["encrypted_field1"], "Expected field to be encrypted in the store")
+
+	t.Log("When the same model is created again")
+	err := s.Create(&mockModel{ID: m.ID})
+	assert.Nil(t, err, "Expected a new ID to be generated")
+
+	t.Log("When a model is found")
+	found := &mockModel{ID: m.ID}
+	assert.Nil(t, s.Find(found))
+	assert.Equal(t, "crypto text", found.EncryptedField1)
+	assert.Equal(t, "plain text", found.PlainTextField)
+
+	t.Log("When a model does not exist")
+	assert.Equal(t, ErrRecordNotFound, s.Find(&mockModel{ID: bson.NewObjectId()}))
+	missing := &mockModel{ID: bson.NewObjectId(), EncryptedField1: "crypto text", BcryptField: "password"}
+	assert.Equal(t, ErrRecordNotFound, s.Update(missing))
+	assert.Equal(t, "crypto text", missing.EncryptedField1, "Expected a failed update to leave the model as it is")
+	assert.Equal(t, "password", missing.BcryptField, "Expected a failed update to leave the model as it is")
+	assert.Equal(t, ErrRecordNotFound, s.Destroy(&mockModel{ID: bson.NewObjectId()}))
+
+	t.Log("When a model is updated")
+	found.PlainTextField = "new text"
+	assert.Nil(t, s.Update(found))
+	assert.Equal(t, "crypto text", found.EncryptedField1, "Expected encrypted field to be plain text")
+	found = &mockModel{ID: m.ID}
+	s.Find(found)
+	assert.Equal(t, "new text", found.PlainTextField)
+	assert.Nil(t, Verify(found, "BcryptField", "password"), "Expected the stored hash to not be hashed again")
+
+	t.Log("When a hashed field is changed to a value which looks like a hash")
+	found.BcryptField = found.BcryptField[:len(found.BcryptField)-1] + "x"
+	lookalike := found.BcryptField
+	assert.Nil(t, s.Update(found))
+	assert.NotEqual(t, lookalike, found.BcryptField, "Expected the value to be hashed")
+	assert.Nil(t, Verify(found, "BcryptField", lookalike))
+
+	t.Log("When models are queried")
+	for i := 2; i <= 4; i++ {
+		s.Create(&mockModel{NumField: i, EncryptedField1: "encrypted text"})
+	}
+	var models mockModels
+	assert.Nil(t, s.FindMany(bson.M{"num_field": bson.M{"$gte": 2}}, &models, Sort("-num_field"), Skip(1), Limit(1)))
+	assert.Equal(t, 1, len(models))
+	assert.Equal(t, 3, models[0].NumField)
+	assert.NotEqual(t, "encrypted text", models[0].EncryptedField1, "Expected FindMany to leave fields encrypted like mongo")
+
+	assert.Nil(t, s.FindMany(bson.M{"num_field": bson.M{"$in": []int{2, 4}}}, &models))
+	assert.Equal(t, 2, len(models))
+
+	byNum := &mockModel{}
+	assert.Nil(t, s.FindBy(bson.M{"num_field": bson.M{"$gt": 1}}, byNum, Sort("-num_field")))
+	assert.Equal(t, 4, byNum.NumField)
+	assert.Equal(t, "encrypted text", byNum.EncryptedField1, "Expected FindBy to decrypt")
+	assert.Equal(t, ErrRecordNotFound, s.FindBy(bson.M{"num_field": 42}, &mockModel{}))
+
+	n, err := s.Count(bson.M{"num_field": bson.M{"$gt": 1}}, &mockModel{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	_, err = s.Count(bson.M{"$where": "true"}, &mockModel{})
+	assert.Equal(t, ErrUnsupportedOperator, err)
+
+	t.Log("When a model is destroyed")
+	assert.Nil(t, s.Destroy(m))
+	assert.Equal(t, ErrRecordNotFound, s.Find(&mockModel{ID: m.ID}))
+	n, _ = s.Count(nil, &mockModel{})
+	assert.Equal(t, 4, n)
+}
@@ -9,60 +9,71 @@ import (
 
 /*
 newSession returns a new mgo session for use with a
-set of operations.  One 'master' session per config.Servers
-is implicitly managed by newSession.  The returned session
+set of operations.  One 'master' session per connection identity
+of the config is implicitly managed by the registry.  The returned session
 is a copy of the 'master' session.
 */
 func newSession(config *MongoConfig) (*mgo.Session, error) {
-	servers := config.Servers
-
-	// Two levels of locks to allow multiple concurrent reads
-	// but only one write at a time
-	sessionRMux.RLock()
-	defer sessionRMux.RUnlock()
-
-	// check if session already exists
-	s, ok := sessionMap[servers]
-	if ok {
-		return s.Copy(), nil
-	}
-
-	// Write lock
-	sessionWMux.Lock()
-	defer sessionWMux.Unlock()
+	_, s, err := newEntrySession(config)
+	return s, err
+}
 
-	// Check if session had been created by another goroutine
-	// in between previous check above and acquiring the
-	// write lodk
-	s, ok = sessionMap[servers]
-	if ok {
-		return s.Copy(), nil
+// newEntrySession returns a new session along with the registry entry it was copied from
+func newEntrySession(config *MongoConfig) (*registryEntry, *mgo.Session, error) {
+	for {
+		e, err := fetchRegistryEntry(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s, ok := e.copySession(); ok {
+			return e, s, nil
+		}
+		// Closed concurrently, use the entry replacing it
 	}
+}
 
-	// Now create new session
-	dialInfo, err := mgo.ParseURL(servers)
+/*
+dial establishes a new connection for the config.
+*/
+func dial(config *MongoConfig, creds *Credentials) (*mgo.Session, error) {
+	dialInfo, err := mgo.ParseURL(config.Servers)
 	if err != nil {
-		return nil, err
+		return nil, redactError(err, config, creds)
+	}
+	if creds.Username != "" {
+		dialInfo.Username = creds.Username
+	}
+	if creds.Password != "" {
+		dialInfo.Password = creds.Password
+	}
+	if config.AuthSource != "" {
+		dialInfo.Source = config.AuthSource
+	}
+	if config.AuthMechanism != "" {
+		dialInfo.Mechanism = config.AuthMechanism
 	}
 
 	// Establish the TLS handshake manually
 	// This needs to be done till mgo package fixes this issue
-	if config.IsSSL {
+	if config.IsSSL || config.TLS != nil {
+		tlsConfig, err := buildTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
 		dialInfo.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
-			return tls.Dial("tcp", addr.String(), &tls.Config{})
+			return tls.Dial("tcp", addr.String(), tlsConfig)
 		}
 	}
 	dialInfo.Timeout = config.Timeout
 
-	// Dial the new connection
-	s, err = mgo.DialWithInfo(dialInfo)
+	s, err := mgo.DialWithInfo(dialInfo)
 	if err != nil {
-		return nil, err
+		return nil, redactError(err, config, creds)
 	}
-
-	// Store new session to session Map
-	sessionMap[servers] = s
-	return s.Copy(), nil
+	if config.PoolLimit > 0 {
+		s.SetPoolLimit(config.PoolLimit)
+	}
+	return s, nil
 }
 
 /*
@@ -0,0 +1,200 @@
+package mgostore
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+OperationEvent describes one call of a CRUD function, including all of its retries.
+*/
+type OperationEvent struct {
+	// Name of the operation, eg. "find" or "update"
+	Operation  string
+	Database   string
+	Collection string
+	// Filter of the operation. Values of encrypted, Secret and hashed fields are redacted
+	Filter bson.M
+	// Time the operation took, including retries and backoff
+	Duration time.Duration
+	// Number of documents read or written
+	Documents int
+	Err       error
+}
+
+/*
+Observer is notified after every operation, eg. to record metrics.
+Observers are called synchronously, so they should return quickly.
+*/
+type Observer interface {
+	ObserveOperation(e *OperationEvent)
+}
+
+/*
+SlowOperationLogger is an Observer which logs the operations taking at least Threshold.
+
+	config.Observers = []mgostore.Observer{&mgostore.SlowOperationLogger{Threshold: 100 * time.Millisecond}}
+*/
+type SlowOperationLogger struct {
+	Threshold time.Duration
+	// Defaults to the standard logger
+	Logger *log.Logger
+}
+
+// ObserveOperation logs the operation when it was slow
+func (l *SlowOperationLogger) ObserveOperation(e *OperationEvent) {
+	if e.Duration < l.Threshold {
+		return
+	}
+	logf := log.Printf
+	if l.Logger != nil {
+		logf = l.Logger.Printf
+	}
+	logf("mgostore: slow %s on %s.%s took %s, documents: %d, filter: %v, error: %v",
+		e.Operation, e.Database, e.Collection, e.Duration, e.Documents, e.Filter, e.Err)
+}
+
+// observe notifies the observers of the config about the finished operation
+func (op *operation) observe(start time.Time, err error) {
+	if len(op.config.Observers) == 0 {
+		return
+	}
+	e := &OperationEvent{
+		Operation:  op.name,
+		Database:   op.config.DBName,
+		Collection: op.collectionName,
+		Filter:     op.filter,
+		Duration:   time.Since(start),
+		Documents:  op.documents,
+		Err:        err,
+	}
+	for _, o := range op.config.Observers {
+		o.ObserveOperation(e)
+	}
+}
+
+/*
+redactFilter returns a copy of the filter in which the values of the sensitive fields are replaced.
+It descends into every document and list of the filter, eg. $and, $or, $elemMatch or embedded documents,
+and the whole value of a sensitive field is replaced, including operator documents like {"$in": [...]}.
+*/
+func redactFilter(filter bson.M, sensitive map[string]bool) bson.M {
+	if filter == nil {
+		return nil
+	}
+	return redactDocument(filter, "", sensitive)
+}
+
+// redactDocument redacts the document found at the dotted path, which is empty at the top level
+func redactDocument(doc bson.M, path string, sensitive map[string]bool) bson.M {
+	redacted := bson.M{}
+	for k, v := range doc {
+		redacted[k] = redactElement(k, v, path, sensitive)
+	}
+	return redacted
+}
+
+// redactElement redacts the value of the key of a document found at the dotted path
+func redactElement(key string, v interface{}, path string, sensitive map[string]bool) interface{} {
+	if strings.HasPrefix(key, "$") {
+		// Operators apply to the field of the document
+		return redactValue(v, path, sensitive)
+	}
+	if path != "" {
+		key = path + "." + key
+	}
+	if isSensitivePath(key, sensitive) {
+		return redactedSecret
+	}
+	return redactValue(v, key, sensitive)
+}
+
+// redactValue redacts the documents within a value found at the dotted path
+func redactValue(v interface{}, path string, sensitive map[string]bool) interface{} {
+	switch d := v.(type) {
+	case bson.M:
+		return redactDocument(d, path, sensitive)
+	case map[string]interface{}:
+		return map[string]interface{}(redactDocument(bson.M(d), path, sensitive))
+	case bson.D:
+		redacted := make(bson.D, len(d))
+		for i, e := range d {
+			redacted[i] = bson.DocElem{Name: e.Name, Value: redactElement(e.Name, e.Value, path, sensitive)}
+		}
+		return redacted
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return v
+	}
+	switch rv.Type().Elem().Kind() {
+	case reflect.Interface, reflect.Map, reflect.Slice, reflect.Ptr:
+	default:
+		// Lists of plain values, like the values of $in, hold no documents
+		return v
+	}
+	redacted := make([]interface{}, rv.Len())
+	for i := range redacted {
+		redacted[i] = redactValue(rv.Index(i).Interface(), path, sensitive)
+	}
+	return redacted
+}
+
+/*
+isSensitivePath reports if the dotted path is a sensitive field, by its full path or its last segment,
+eg. "profile.ssn" and "addresses.0.ssn" are both sensitive when "ssn" is.
+*/
+func isSensitivePath(path string, sensitive map[string]bool) bool {
+	if sensitive[path] {
+		return true
+	}
+	i := strings.LastIndex(path, ".")
+	return i >= 0 && sensitive[path[i+1:]]
+}
+
+/*
+sensitiveFields returns the bson names of the fields of a model whose values must not be logged:
+encrypted, Secret and hashed fields. Those of embedded structs are included with their dotted paths.
+The model may also be a pointer to a slice of models, as passed to FindMany.
+*/
+func sensitiveFields(m interface{}) map[string]bool {
+	sensitive := map[string]bool{}
+	addSensitiveFields(reflect.TypeOf(m), "", sensitive, map[reflect.Type]bool{})
+	return sensitive
+}
+
+// addSensitiveFields adds the sensitive fields of the struct type, found at the dotted path
+func addSensitiveFields(t reflect.Type, path string, sensitive map[string]bool, visiting map[reflect.Type]bool) {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || visiting[t] || t == typeTime {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("bson")
+		if (f.PkgPath != "" && !f.Anonymous) || tag == "-" {
+			continue
+		}
+		if hasFlag(strings.Split(tag, ",")[1:], "inline") {
+			addSensitiveFields(f.Type, path, sensitive, visiting)
+			continue
+		}
+		name := fieldBSONName(f)
+		if path != "" {
+			name = path + "." + name
+		}
+		if encryptionTag(f) != "" || f.Tag.Get("hash") != "" || f.Type == reflect.TypeOf(Secret("")) {
+			sensitive[name] = true
+			continue
+		}
+		addSensitiveFields(f.Type, name, sensitive, visiting)
+	}
+}
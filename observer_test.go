@@ -0,0 +1,163 @@
+package mgostore
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type recordingObserver struct {
+	events []*OperationEvent
+}
+
+func (o *recordingObserver) ObserveOperation(e *OperationEvent) {
+	o.events = append(o.events, e)
+}
+
+func Test_sensitiveFields(t *testing.T) {
+	expected := map[string]bool{
+		"encrypted_field1": true,
+		"num_field":        true,
+		"encrypted_field2": true,
+		"secret_field":     true,
+		"bcrypt_field":     true,
+		"argon2id_field":   true,
+	}
+	assert.Equal(t, expected, sensitiveFields(&mockModel{}))
+
+	t.Log("When the model is a slice of models")
+	var models []mockModel
+	assert.Equal(t, expected, sensitiveFields(&models))
+}
+
+func Test_redactFilter(t *testing.T) {
+	sensitive := sensitiveFields(&mockModel{})
+	filter := bson.M{
+		"plain_text_field": "plain text",
+		"secret_field":     bson.M{"$in": []string{"secret1", "secret2"}},
+		"$or": []bson.M{
+			{"encrypted_field1": "crypto text"},
+			{"num_field": bson.M{"$gt": 1}},
+			{"plain_text_field": "other text"},
+		},
+	}
+
+	redacted := redactFilter(filter, sensitive)
+	assert.Equal(t, bson.M{
+		"plain_text_field": "plain text",
+		"secret_field":     "[REDACTED]",
+		"$or": []interface{}{
+			bson.M{"encrypted_field1": "[REDACTED]"},
+			bson.M{"num_field": "[REDACTED]"},
+			bson.M{"plain_text_field": "other text"},
+		},
+	}, redacted)
+	assert.Equal(t, "crypto text", filter["$or"].([]bson.M)[0]["encrypted_field1"], "Expected the filter to be left unchanged")
+
+	t.Log("When sensitive fields are nested in operators, documents and lists")
+	filter = bson.M{
+		"$and": []interface{}{
+			map[string]interface{}{"encrypted_field1": bson.M{"$in": []string{"crypto text"}}},
+			bson.D{{Name: "secret_field", Value: "secret"}, {Name: "plain_text_field", Value: "plain text"}},
+		},
+		"items":   bson.M{"$elemMatch": bson.M{"secret_field": "secret", "qty": 2}},
+		"profile": bson.M{"encrypted_field1": "crypto text"},
+		"tags":    bson.M{"$in": []string{"a", "b"}},
+	}
+	assert.Equal(t, bson.M{
+		"$and": []interface{}{
+			map[string]interface{}{"encrypted_field1": "[REDACTED]"},
+			bson.D{{Name: "secret_field", Value: "[REDACTED]"}, {Name: "plain_text_field", Value: "plain text"}},
+		},
+		"items":   bson.M{"$elemMatch": bson.M{"secret_field": "[REDACTED]", "qty": 2}},
+		"profile": bson.M{"encrypted_field1": "[REDACTED]"},
+		"tags":    bson.M{"$in": []string{"a", "b"}},
+	}, redactFilter(filter, sensitive))
+
+	t.Log("When sensitive fields are matched by their dotted path")
+	assert.Equal(t, bson.M{
+		"profile.ssn":       "[REDACTED]",
+		"addresses.0.ssn":   "[REDACTED]",
+		"profile.name":      "name",
+		"contact.phone":     "[REDACTED]",
+		"contact.phone_ext": "42",
+	}, redactFilter(bson.M{
+		"profile.ssn":       "123",
+		"addresses.0.ssn":   "123",
+		"profile.name":      "name",
+		"contact.phone":     "555",
+		"contact.phone_ext": "42",
+	}, map[string]bool{"ssn": true, "contact.phone": true}))
+
+	t.Log("When there is no filter")
+	assert.Nil(t, redactFilter(nil, sensitive))
+}
+
+func Test_sensitiveFieldsOfEmbeddedStructs(t *testing.T) {
+	type profile struct {
+		Name string `bson:"name"`
+		SSN  Secret `bson:"ssn"`
+	}
+	type person struct {
+		mockModel `bson:",inline"`
+		Profile   profile   `bson:"profile"`
+		Contacts  []profile `bson:"contacts"`
+	}
+	sensitive := sensitiveFields(&person{})
+	assert.True(t, sensitive["profile.ssn"], "Expected fields of embedded documents by their path")
+	assert.True(t, sensitive["contacts.ssn"], "Expected fields of lists of documents by their path")
+	assert.True(t, sensitive["encrypted_field1"], "Expected fields of inlined structs")
+	assert.False(t, sensitive["profile.name"])
+}
+
+func TestSlowOperationLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := &SlowOperationLogger{Threshold: 100 * time.Millisecond, Logger: log.New(&buf, "", 0)}
+
+	t.Log("When the operation is fast")
+	l.ObserveOperation(&OperationEvent{Operation: "find", Duration: 99 * time.Millisecond})
+	assert.Equal(t, "", buf.String(), "Expected nothing to be logged")
+
+	t.Log("When the operation is slow")
+	l.ObserveOperation(&OperationEvent{
+		Operation:  "find_by",
+		Database:   "test",
+		Collection: "mock_models",
+		Filter:     bson.M{"secret_field": "[REDACTED]"},
+		Duration:   150 * time.Millisecond,
+		Documents:  1,
+	})
+	assert.Equal(t, "mgostore: slow find_by on test.mock_models took 150ms, documents: 1, filter: map[secret_field:[REDACTED]], error: <nil>\n", buf.String())
+}
+
+func Test_operationObserve(t *testing.T) {
+	o := &recordingObserver{}
+	config := &MongoConfig{
+		Servers:   "observer_invalid_server",
+		DBName:    "test",
+		Timeout:   10 * time.Millisecond,
+		Observers: []Observer{o},
+	}
+	op := newOperation(config, "mock_models", nil)
+	op.name = "find_by"
+	op.filter = bson.M{"plain_text_field": "plain text"}
+
+	err := op.run(func(c *mgo.Collection) error {
+		return nil
+	})
+	assert.NotNil(t, err, "Expected the connection to fail")
+	assert.Equal(t, 1, len(o.events), "Expected one event per operation")
+	e := o.events[0]
+	assert.Equal(t, "find_by", e.Operation)
+	assert.Equal(t, "test", e.Database)
+	assert.Equal(t, "mock_models", e.Collection)
+	assert.Equal(t, bson.M{"plain_text_field": "plain text"}, e.Filter)
+	assert.Equal(t, 0, e.Documents)
+	assert.Equal(t, err, e.Err)
+	assert.True(t, e.Duration > 0)
+}
@@ -0,0 +1,201 @@
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Option overrides a setting of the MongoConfig for a single call of a CRUD function.
+
+	mgostore.FindBy(whereClause, mam, mgostore.WithReadPreference(mgo.SecondaryPreferred))
+*/
+type Option func(*operation)
+
+/*
+WithConsistency overrides MongoConfig.Consistency and MongoConfig.ReadPreference,
+as both set the mode of the session. When combined with WithReadPreference the last one wins.
+*/
+func WithConsistency(mode mgo.Mode) Option {
+	return func(op *operation) {
+		op.mode = &mode
+	}
+}
+
+/*
+WithReadPreference overrides MongoConfig.ReadPreference and MongoConfig.Consistency,
+as both set the mode of the session. When combined with WithConsistency the last one wins.
+*/
+func WithReadPreference(mode mgo.Mode) Option {
+	return func(op *operation) {
+		op.mode = &mode
+	}
+}
+
+// WithWriteConcern overrides MongoConfig.WriteConcern
+func WithWriteConcern(wc *WriteConcern) Option {
+	return func(op *operation) {
+		op.writeConcern = wc
+	}
+}
+
+// Skip skips the first n matches of FindManyWithOptions and Count
+func Skip(n int) Option {
+	return func(op *operation) {
+		op.skip = n
+	}
+}
+
+// Limit limits FindManyWithOptions and Count to n matches
+func Limit(n int) Option {
+	return func(op *operation) {
+		op.limit = n
+	}
+}
+
+/*
+Sort orders the matches of FindBy and FindManyWithOptions by the fields,
+eg. Sort("-created_at", "name"). A field prefixed with - is sorted in descending order.
+*/
+func Sort(fields ...string) Option {
+	return func(op *operation) {
+		op.sort = fields
+	}
+}
+
+/*
+operation holds the settings of a single call of a CRUD function,
+taken from the MongoConfig and overridden by the options of the call.
+*/
+type operation struct {
+	// name and filter of the operation, as reported to observers
+	name   string
+	filter bson.M
+	// context of the caller, which holds the parent span
+	ctx            context.Context
+	config         *MongoConfig
+	collectionName string
+	// mode of the session, the consistency or read preference
+	mode         *mgo.Mode
+	writeConcern *WriteConcern
+	skip         int
+	limit        int
+	sort         []string
+	// relation fields to preload
+	preload []string
+	// whether Find reads from the DB instead of the cache of the model
+	skipCache bool
+	// whether the operation may be retried after a transient error
+	idempotent bool
+	// number of the current attempt, starting at 1
+	attempt int
+	// number of documents read or written
+	documents int
+}
+
+func newOperation(config *MongoConfig, collectionName string, opts []Option) *operation {
+	op := &operation{
+		config:         config,
+		collectionName: collectionName,
+		mode:           config.Consistency,
+		writeConcern:   config.WriteConcern,
+	}
+	if config.ReadPreference != nil {
+		// It takes precedence over the consistency of the config, not over the options of the call
+		op.mode = config.ReadPreference
+	}
+	for _, opt := range opts {
+		opt(op)
+	}
+	return op
+}
+
+/*
+newModelOperation returns the named operation on the collection of the model.
+The filter is reported to observers with the values of the sensitive fields of the model redacted,
+tracers only get its shape.
+*/
+func newModelOperation(name string, m Model, filter bson.M, opts []Option) *operation {
+	op := newOperation(m.DBConfig(), m.CollectionName(), opts)
+	op.name = name
+	op.filter = redactFilter(filter, sensitiveFields(m))
+	return op
+}
+
+/*
+run calls fn with the collection of the operation, on a session which is configured
+for the operation and closed afterwards.
+Idempotent operations are retried on a new session according to the RetryPolicy of the config
+as long as they fail with transient errors. The operation is traced in a single span,
+and the observers of the config are notified once it has finished.
+Errors are returned wrapped in the matching typed error.
+*/
+func (op *operation) run(fn func(c *mgo.Collection) error) error {
+	start := time.Now()
+	span := op.startSpan()
+	err := wrapError(op.runWithRetries(fn))
+	op.endSpan(span, err)
+	op.observe(start, err)
+	return err
+}
+
+// runWithRetries calls fn until it succeeds or the retry policy gives up
+func (op *operation) runWithRetries(fn func(c *mgo.Collection) error) error {
+	policy := op.config.RetryPolicy
+	for op.attempt = 1; ; op.attempt++ {
+		err := op.runOnce(fn)
+		if err == nil || !op.idempotent || policy == nil || op.attempt >= policy.MaxAttempts || !IsTransient(err) {
+			return err
+		}
+		time.Sleep(policy.backoff(op.attempt))
+	}
+}
+
+// runOnce calls fn on a new session, unless the circuit breaker of the connection is open
+func (op *operation) runOnce(fn func(c *mgo.Collection) error) (err error) {
+	if cb := fetchCircuitBreaker(op.config); cb != nil {
+		if !cb.allow() {
+			return ErrCircuitOpen
+		}
+		defer func() {
+			cb.done(err)
+		}()
+	}
+	e, session, err := newEntrySession(op.config)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	op.configure(session)
+	c := session.DB(op.config.DBName).C(op.collectionName)
+	if c == nil {
+		return ErrMongoCollectionNotFetched
+	}
+	err = fn(c)
+	if IsTransient(err) || isAuthError(err) {
+		e.setLastError(err)
+	}
+	if isAuthError(err) && op.config.CredentialProvider != nil {
+		// The credentials might have been rotated, the next operation uses the new ones
+		RefreshCredentials(op.config)
+	}
+	return err
+}
+
+// retried reports if the current attempt is a retry
+func (op *operation) retried() bool {
+	return op.attempt > 1
+}
+
+// configure applies the mode and write concern to the session
+func (op *operation) configure(session *mgo.Session) {
+	if op.mode != nil {
+		session.SetMode(*op.mode, true)
+	}
+	if op.writeConcern != nil {
+		session.SetSafe(op.writeConcern.safe())
+	}
+}
@@ -0,0 +1,52 @@
+package mgostore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func Test_newOperation(t *testing.T) {
+	secondary := mgo.Secondary
+	monotonic := mgo.Monotonic
+	config := &MongoConfig{
+		Consistency:    &monotonic,
+		ReadPreference: &secondary,
+		WriteConcern:   &WriteConcern{W: 1},
+	}
+
+	t.Log("When no options are passed")
+	op := newOperation(config, "mock_models", nil)
+	assert.Equal(t, "mock_models", op.collectionName)
+	assert.Equal(t, mgo.Secondary, *op.mode, "Expected the read preference of the config to take precedence")
+	assert.Equal(t, 1, op.writeConcern.W, "Expected the write concern of the config")
+
+	t.Log("When options are passed")
+	op = newOperation(config, "mock_models", []Option{
+		WithConsistency(mgo.Eventual),
+		WithReadPreference(mgo.Nearest),
+		WithWriteConcern(&WriteConcern{WMode: "majority"}),
+		Skip(5),
+		Limit(10),
+	})
+	assert.Equal(t, mgo.Nearest, *op.mode, "Expected the last mode of the call to be used")
+	assert.Equal(t, "majority", op.writeConcern.WMode, "Expected the write concern to be overridden")
+	assert.Equal(t, 5, op.skip)
+	assert.Equal(t, 10, op.limit)
+	assert.Equal(t, mgo.Secondary, *config.ReadPreference, "Expected the config to be left as it is")
+
+	t.Log("When the consistency of the call overrides the read preference of the config")
+	op = newOperation(config, "mock_models", []Option{WithConsistency(mgo.Strong)})
+	assert.Equal(t, mgo.Strong, *op.mode, "Expected the consistency of the call to be used")
+
+	t.Log("When the config only has a consistency")
+	op = newOperation(&MongoConfig{Consistency: &monotonic}, "mock_models", []Option{WithReadPreference(mgo.Nearest)})
+	assert.Equal(t, mgo.Nearest, *op.mode, "Expected the read preference of the call to be used")
+}
+
+func TestWriteConcern_safe(t *testing.T) {
+	wc := &WriteConcern{W: 2, WMode: "majority", J: true, WTimeout: 2 * time.Second}
+	assert.Equal(t, &mgo.Safe{W: 2, WMode: "majority", J: true, WTimeout: 2000}, wc.safe())
+}
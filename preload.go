@@ -0,0 +1,313 @@
+package mgostore
+
+import (
+	"reflect"
+	"strings"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Preload loads the related models of the fields, which are declared by their ref tag,
+along with the models returned by Find, FindBy and FindManyWithOptions.
+Each relation is loaded with a single $in query for all models, and the related models are decrypted.
+
+	type User struct {
+		ID       bson.ObjectId   `bson:"_id,omitempty"`
+		TeamID   bson.ObjectId   `bson:"team_id"`
+		GroupIDs []bson.ObjectId `bson:"group_ids"`
+		// has-many: orders whose user_id is the ID of the user
+		Orders []Order `bson:"-" ref:"orders,foreign=user_id"`
+		// belongs-to: the team whose ID is the team_id of the user
+		Team *Team `bson:"-" ref:"teams,local=team_id"`
+		// many-to-many: the groups whose IDs are in the group_ids of the user
+		Groups []Group `bson:"-" ref:"groups,local=group_ids"`
+	}
+
+	err := mgostore.Find(user, mgostore.Preload("Orders", "Team"))
+*/
+func Preload(fields ...string) Option {
+	return func(op *operation) {
+		op.preload = append(op.preload, fields...)
+	}
+}
+
+// refTag is the parsed ref tag of a relation field
+type refTag struct {
+	// collection of the related models
+	collection string
+	// bson name of the key of the related models which holds the ID of the model
+	foreign string
+	// bson name of the key of the model which holds the IDs of the related models
+	local string
+	// what Destroy does with the related models which reference the model, see DeleteRule
+	onDelete DeleteRule
+}
+
+// parseRefTag parses a ref tag like "orders,foreign=user_id"
+func parseRefTag(tag string) refTag {
+	parts := strings.Split(tag, ",")
+	ref := refTag{collection: strings.TrimSpace(parts[0])}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "foreign":
+			ref.foreign = kv[1]
+		case "local":
+			ref.local = kv[1]
+		case "ondelete":
+			ref.onDelete = DeleteRule(kv[1])
+		}
+	}
+	return ref
+}
+
+// relation is a field of a model which holds related models
+type relation struct {
+	field reflect.StructField
+	ref   refTag
+	// struct type of the related models
+	elemType reflect.Type
+}
+
+/*
+fetchRelation returns the relation declared by the ref tag of the named field of the model type.
+The field must hold a related model, a pointer to one, or a slice of either.
+It must be tagged bson:"-", otherwise the preloaded models would be stored with the model.
+*/
+func fetchRelation(t reflect.Type, fieldName string) (*relation, error) {
+	f, ok := t.FieldByName(fieldName)
+	if !ok {
+		return nil, ErrUnknownRelation
+	}
+	ref := parseRefTag(f.Tag.Get("ref"))
+	if ref.collection == "" || (ref.foreign == "") == (ref.local == "") {
+		return nil, ErrUnknownRelation
+	}
+	if f.Tag.Get("bson") != "-" {
+		return nil, ErrStoredRelation
+	}
+	elemType := f.Type
+	if elemType.Kind() == reflect.Slice {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct || !reflect.PtrTo(elemType).Implements(reflect.TypeOf((*Model)(nil)).Elem()) {
+		return nil, ErrUnknownRelation
+	}
+	return &relation{field: f, ref: ref, elemType: elemType}, nil
+}
+
+/*
+relatedLoader loads the documents of the collection which match the filter into out,
+a pointer to a slice of related models. m is a related model, for its DB config.
+*/
+type relatedLoader func(m Model, collection string, filter bson.M, out interface{}) error
+
+/*
+preloadRelations loads the named relations of the models, which are pointers to structs
+of the same type, and assigns the related models to their fields.
+*/
+func preloadRelations(models []reflect.Value, fields []string, load relatedLoader) error {
+	if len(models) == 0 {
+		return nil
+	}
+	for _, name := range fields {
+		rel, err := fetchRelation(models[0].Elem().Type(), name)
+		if err != nil {
+			return err
+		}
+		if err = preloadRelation(models, rel, load); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func preloadRelation(models []reflect.Value, rel *relation, load relatedLoader) error {
+	// key of the related models which is matched against the IDs
+	key := "_id"
+	if rel.ref.foreign != "" {
+		key = rel.ref.foreign
+	}
+	// IDs which the related models are looked up by, per model
+	keys := make([][]interface{}, len(models))
+	var ids []interface{}
+	for i, m := range models {
+		var values []interface{}
+		if rel.ref.foreign != "" {
+			values = []interface{}{m.Elem().FieldByName("ID").Interface()}
+		} else if v, ok := fieldByBSONName(m.Elem(), rel.ref.local); ok {
+			values = flattenIDs(v)
+		}
+		for _, value := range values {
+			id, err := normalizeValue(value)
+			if err != nil {
+				return err
+			}
+			keys[i] = append(keys[i], id)
+			ids = appendUnique(ids, id)
+		}
+	}
+
+	related := reflect.New(reflect.SliceOf(rel.elemType))
+	if len(ids) > 0 {
+		prototype := reflect.New(rel.elemType).Interface().(Model)
+		if err := load(prototype, rel.ref.collection, bson.M{key: bson.M{"$in": ids}}, related.Interface()); err != nil {
+			return err
+		}
+	}
+	relatedModels := related.Elem()
+	relatedKeys := make([]interface{}, relatedModels.Len())
+	for j := 0; j < relatedModels.Len(); j++ {
+		rm := relatedModels.Index(j).Addr()
+		if err := decryptFields(rm.Interface().(Model)); err != nil {
+			return err
+		}
+		if v, ok := fieldByBSONName(rm.Elem(), key); ok {
+			relatedKeys[j], _ = normalizeValue(v.Interface())
+		}
+	}
+
+	for i, m := range models {
+		var matches []reflect.Value
+		if rel.ref.foreign != "" {
+			// has-many, in the order the related models were loaded
+			for j := range relatedKeys {
+				if len(keys[i]) > 0 && valuesEqual(relatedKeys[j], keys[i][0]) {
+					matches = append(matches, relatedModels.Index(j))
+				}
+			}
+		} else {
+			// belongs-to and many-to-many, in the order of the IDs in the model
+			for _, id := range keys[i] {
+				for j := range relatedKeys {
+					if valuesEqual(relatedKeys[j], id) {
+						matches = append(matches, relatedModels.Index(j))
+						break
+					}
+				}
+			}
+		}
+		assignRelated(m.Elem().FieldByIndex(rel.field.Index), matches)
+	}
+	return nil
+}
+
+// assignRelated sets a relation field to the related models, which are struct values
+func assignRelated(f reflect.Value, matches []reflect.Value) {
+	switch f.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(f.Type(), 0, len(matches))
+		for _, rm := range matches {
+			if f.Type().Elem().Kind() == reflect.Ptr {
+				p := reflect.New(rm.Type())
+				p.Elem().Set(rm)
+				rm = p
+			}
+			s = reflect.Append(s, rm)
+		}
+		f.Set(s)
+	case reflect.Ptr:
+		if len(matches) == 0 {
+			f.Set(reflect.Zero(f.Type()))
+			return
+		}
+		p := reflect.New(matches[0].Type())
+		p.Elem().Set(matches[0])
+		f.Set(p)
+	default:
+		if len(matches) == 0 {
+			f.Set(reflect.Zero(f.Type()))
+			return
+		}
+		f.Set(matches[0])
+	}
+}
+
+// fieldByBSONName returns the field of a struct which is stored with the bson name
+func fieldByBSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" && fieldBSONName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// flattenIDs returns the IDs held by a field, which holds either a single ID or a slice of them
+func flattenIDs(v reflect.Value) []interface{} {
+	if v.Kind() == reflect.Slice && v.Type() != reflect.TypeOf([]byte(nil)) {
+		ids := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ids = append(ids, v.Index(i).Interface())
+		}
+		return ids
+	}
+	if v.IsZero() {
+		return nil
+	}
+	return []interface{}{v.Interface()}
+}
+
+func appendUnique(values []interface{}, value interface{}) []interface{} {
+	for _, v := range values {
+		if valuesEqual(v, value) {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// modelPointers returns pointers to the models of a Models, which points to a slice of structs or pointers
+func modelPointers(models interface{}) []reflect.Value {
+	v := reflect.ValueOf(models).Elem()
+	pointers := make([]reflect.Value, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		if e.Kind() == reflect.Ptr {
+			if !e.IsNil() {
+				pointers = append(pointers, e)
+			}
+			continue
+		}
+		pointers = append(pointers, e.Addr())
+	}
+	return pointers
+}
+
+// preloadFromMongo loads related models with operations which share the context and read settings of op
+func (op *operation) preloadFromMongo(models []reflect.Value) error {
+	if len(op.preload) == 0 {
+		return nil
+	}
+	return preloadRelations(models, op.preload, op.mongoLoader("preload"))
+}
+
+// mongoLoader returns a relatedLoader whose operations share the context and read settings of op
+func (op *operation) mongoLoader(name string) relatedLoader {
+	return func(m Model, collection string, filter bson.M, out interface{}) error {
+		rop := op.relatedOperation(name, m, collection, filter)
+		rop.mode = op.mode
+		rop.idempotent = true
+		return rop.run(func(c *mgo.Collection) error {
+			return c.Find(filter).All(out)
+		})
+	}
+}
+
+// relatedOperation returns an operation on the collection of related models, in the context of op
+func (op *operation) relatedOperation(name string, m Model, collection string, filter bson.M) *operation {
+	rop := newOperation(m.DBConfig(), collection, nil)
+	rop.name = name
+	rop.filter = redactFilter(filter, sensitiveFields(m))
+	rop.ctx = op.ctx
+	return rop
+}
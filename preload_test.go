@@ -0,0 +1,128 @@
+package mgostore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type preloadUser struct {
+	ID       bson.ObjectId   `bson:"_id,omitempty"`
+	Name     string          `bson:"name"`
+	TeamID   bson.ObjectId   `bson:"team_id,omitempty"`
+	GroupIDs []bson.ObjectId `bson:"group_ids"`
+	Orders   []preloadOrder  `bson:"-" ref:"orders,foreign=user_id"`
+	Team     *preloadTeam    `bson:"-" ref:"teams,local=team_id"`
+	Groups   []*preloadTeam  `bson:"-" ref:"teams,local=group_ids"`
+	Invalid  []preloadOrder  `bson:"-" ref:"orders"`
+}
+
+type preloadUsers []preloadUser
+
+type preloadOrder struct {
+	ID     bson.ObjectId `bson:"_id,omitempty"`
+	UserID bson.ObjectId `bson:"user_id"`
+	Item   string        `bson:"item" encrypt:"aes"`
+}
+
+type preloadTeam struct {
+	ID   bson.ObjectId `bson:"_id,omitempty"`
+	Name string        `bson:"name"`
+}
+
+func (m *preloadUser) CollectionName() string  { return "users" }
+func (m *preloadUser) DBConfig() *MongoConfig  { return testMongoConfig() }
+func (m preloadUsers) CollectionName() string  { return "users" }
+func (m preloadUsers) DBConfig() *MongoConfig  { return testMongoConfig() }
+func (m *preloadOrder) CollectionName() string { return "orders" }
+func (m *preloadOrder) DBConfig() *MongoConfig { return testMongoConfig() }
+func (m *preloadTeam) CollectionName() string  { return "teams" }
+func (m *preloadTeam) DBConfig() *MongoConfig  { return testMongoConfig() }
+
+func Test_parseRefTag(t *testing.T) {
+	assert.Equal(t, refTag{collection: "orders", foreign: "user_id"}, parseRefTag("orders,foreign=user_id"))
+	assert.Equal(t, refTag{collection: "teams", local: "team_id"}, parseRefTag("teams, local=team_id"))
+	assert.Equal(t, refTag{}, parseRefTag(""))
+}
+
+func Test_fetchRelation(t *testing.T) {
+	userType := reflect.TypeOf(preloadUser{})
+	rel, err := fetchRelation(userType, "Orders")
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.TypeOf(preloadOrder{}), rel.elemType)
+	rel, err = fetchRelation(userType, "Groups")
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.TypeOf(preloadTeam{}), rel.elemType, "Expected the struct type of a slice of pointers")
+
+	t.Log("When the field is not a relation")
+	_, err = fetchRelation(userType, "Name")
+	assert.Equal(t, ErrUnknownRelation, err)
+	_, err = fetchRelation(userType, "Invalid")
+	assert.Equal(t, ErrUnknownRelation, err, "Expected foreign or local to be required")
+	_, err = fetchRelation(userType, "Missing")
+	assert.Equal(t, ErrUnknownRelation, err)
+
+	t.Log("When the relation field would be stored")
+	storedType := reflect.TypeOf(struct {
+		Orders []preloadOrder `bson:"orders" ref:"orders,foreign=user_id"`
+		Team   *preloadTeam   `ref:"teams,local=team_id"`
+	}{})
+	_, err = fetchRelation(storedType, "Orders")
+	assert.Equal(t, ErrStoredRelation, err)
+	_, err = fetchRelation(storedType, "Team")
+	assert.Equal(t, ErrStoredRelation, err, "Expected untagged fields to be refused")
+}
+
+func TestPreload(t *testing.T) {
+	setTestEnvVars()
+	s := NewMemoryStore()
+	red := &preloadTeam{Name: "red"}
+	blue := &preloadTeam{Name: "blue"}
+	s.Create(red)
+	s.Create(blue)
+	ada := &preloadUser{Name: "ada", TeamID: red.ID, GroupIDs: []bson.ObjectId{blue.ID, red.ID}}
+	bob := &preloadUser{Name: "bob"}
+	s.Create(ada)
+	s.Create(bob)
+	for _, item := range []string{"book", "pen"} {
+		s.Create(&preloadOrder{UserID: ada.ID, Item: item})
+	}
+
+	t.Log("When a model is found with its relations")
+	user := &preloadUser{ID: ada.ID}
+	assert.Nil(t, s.Find(user, Preload("Orders", "Team", "Groups")))
+	assert.Equal(t, 2, len(user.Orders), "Expected the has-many relation")
+	assert.Equal(t, "book", user.Orders[0].Item, "Expected related models to be decrypted")
+	assert.Equal(t, "pen", user.Orders[1].Item)
+	assert.Equal(t, "red", user.Team.Name, "Expected the belongs-to relation")
+	assert.Equal(t, 2, len(user.Groups), "Expected the many-to-many relation")
+	assert.Equal(t, "blue", user.Groups[0].Name, "Expected the order of the IDs")
+	assert.Equal(t, "red", user.Groups[1].Name)
+
+	t.Log("When many models are found with their relations")
+	var users preloadUsers
+	assert.Nil(t, s.FindMany(bson.M{}, &users, Sort("name"), Preload("Orders", "Team")))
+	assert.Equal(t, 2, len(users))
+	assert.Equal(t, 2, len(users[0].Orders))
+	assert.Equal(t, 0, len(users[1].Orders), "Expected no orders of bob")
+	assert.NotNil(t, users[0].Team)
+	assert.Nil(t, users[1].Team, "Expected no team of bob")
+
+	t.Log("When a field is not a relation")
+	assert.Equal(t, ErrUnknownRelation, s.FindBy(bson.M{"name": "ada"}, &preloadUser{}, Preload("Name")))
+}
+
+func Test_preloadRelations(t *testing.T) {
+	users := []preloadUser{{ID: bson.NewObjectId()}, {ID: bson.NewObjectId()}}
+	var queries []bson.M
+	load := func(m Model, collection string, filter bson.M, out interface{}) error {
+		assert.Equal(t, "orders", collection)
+		queries = append(queries, filter)
+		return nil
+	}
+	err := preloadRelations(modelPointers(&users), []string{"Orders"}, load)
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{{"user_id": bson.M{"$in": []interface{}{users[0].ID, users[1].ID}}}}, queries, "Expected a single $in query")
+}
@@ -0,0 +1,442 @@
+package mgostore
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Evaluation of mongo queries on documents in memory, as used by the MemoryStore.
+Documents and filters are normalized by a bson round trip first, so that values
+have the same types as documents read from mongo.
+*/
+
+// normalizeDocument returns the value as a document with the types mgo decodes into
+func normalizeDocument(v interface{}) (bson.M, error) {
+	doc := bson.M{}
+	if v == nil {
+		return doc, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// normalizeValue returns a single value with the type mgo decodes it into
+func normalizeValue(v interface{}) (interface{}, error) {
+	doc, err := normalizeDocument(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+	return doc["v"], nil
+}
+
+// matchDocument reports if a normalized document matches a normalized filter
+func matchDocument(doc bson.M, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		var matched bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			matched, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, ErrUnsupportedOperator
+			}
+			value, found := lookupPath(doc, key)
+			matched, err = matchCondition(value, found, cond)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchLogical evaluates $and, $or and $nor
+func matchLogical(doc bson.M, operator string, cond interface{}) (bool, error) {
+	filters, ok := cond.([]interface{})
+	if !ok {
+		return false, ErrUnsupportedOperator
+	}
+	for _, f := range filters {
+		filter, ok := f.(bson.M)
+		if !ok {
+			return false, ErrUnsupportedOperator
+		}
+		matched, err := matchDocument(doc, filter)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case operator == "$and" && !matched:
+			return false, nil
+		case operator == "$or" && matched:
+			return true, nil
+		case operator == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return operator != "$or", nil
+}
+
+/*
+lookupPath returns the value at a dotted path of the document.
+Like mongo, a path through an array of documents returns the values of all its documents.
+*/
+func lookupPath(doc bson.M, path string) (interface{}, bool) {
+	parts := strings.SplitN(path, ".", 2)
+	value, found := doc[parts[0]]
+	if !found || len(parts) == 1 {
+		return value, found
+	}
+	switch v := value.(type) {
+	case bson.M:
+		return lookupPath(v, parts[1])
+	case []interface{}:
+		var values []interface{}
+		for _, e := range v {
+			if m, ok := e.(bson.M); ok {
+				if ev, ok := lookupPath(m, parts[1]); ok {
+					values = append(values, ev)
+				}
+			}
+		}
+		return values, len(values) > 0
+	}
+	return nil, false
+}
+
+// isOperatorDocument reports if the condition of a field is made of operators, eg. {$gt: 1}
+func isOperatorDocument(cond interface{}) (bson.M, bool) {
+	m, ok := cond.(bson.M)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// matchCondition reports if the value of a field matches its condition in the filter
+func matchCondition(value interface{}, found bool, cond interface{}) (bool, error) {
+	operators, ok := isOperatorDocument(cond)
+	if !ok {
+		return matchEquals(value, found, cond), nil
+	}
+	for operator, operand := range operators {
+		matched, err := matchOperator(value, found, operator, operand, operators)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchOperator(value interface{}, found bool, operator string, operand interface{}, operators bson.M) (bool, error) {
+	switch operator {
+	case "$eq":
+		return matchEquals(value, found, operand), nil
+	case "$ne":
+		return !matchEquals(value, found, operand), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return found && anyElement(value, func(v interface{}) bool {
+			c, ok := compareValues(v, operand)
+			return ok && compareMatches(operator, c)
+		}), nil
+	case "$in", "$nin":
+		list, ok := operand.([]interface{})
+		if !ok {
+			return false, ErrUnsupportedOperator
+		}
+		in := false
+		for _, e := range list {
+			if matchEquals(value, found, e) {
+				in = true
+				break
+			}
+		}
+		return in == (operator == "$in"), nil
+	case "$exists":
+		return found == truthy(operand), nil
+	case "$not":
+		matched, err := matchCondition(value, found, operand)
+		return !matched, err
+	case "$regex":
+		options, _ := operators["$options"].(string)
+		pattern, ok := operand.(string)
+		if !ok {
+			return false, ErrUnsupportedOperator
+		}
+		return found && matchRegex(value, bson.RegEx{Pattern: pattern, Options: options}), nil
+	case "$options":
+		// Evaluated along with $regex
+		return true, nil
+	case "$size":
+		list, ok := value.([]interface{})
+		return ok && valuesEqual(len(list), operand), nil
+	case "$all":
+		all, ok := operand.([]interface{})
+		if !ok {
+			return false, ErrUnsupportedOperator
+		}
+		for _, e := range all {
+			if !matchEquals(value, found, e) {
+				return false, nil
+			}
+		}
+		return found, nil
+	case "$elemMatch":
+		list, ok := value.([]interface{})
+		filter, isFilter := operand.(bson.M)
+		if !ok || !isFilter {
+			return false, nil
+		}
+		_, isOperators := isOperatorDocument(filter)
+		for _, e := range list {
+			var matched bool
+			var err error
+			if doc, isDoc := e.(bson.M); isDoc && !isOperators {
+				matched, err = matchDocument(doc, filter)
+			} else {
+				matched, err = matchCondition(e, true, filter)
+			}
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+	return false, ErrUnsupportedOperator
+}
+
+/*
+matchEquals reports if the value equals the condition. Like mongo, an array matches
+when one of its elements or the whole array equals the condition, a missing field
+matches nil and a regular expression matches strings.
+*/
+func matchEquals(value interface{}, found bool, cond interface{}) bool {
+	if !found {
+		return cond == nil
+	}
+	if re, ok := cond.(bson.RegEx); ok {
+		return matchRegex(value, re)
+	}
+	if valuesEqual(value, cond) {
+		return true
+	}
+	if list, ok := value.([]interface{}); ok {
+		for _, e := range list {
+			if valuesEqual(e, cond) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchRegex(value interface{}, re bson.RegEx) bool {
+	flags := ""
+	for _, o := range re.Options {
+		if strings.ContainsRune("ims", o) {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		flags = "(?" + flags + ")"
+	}
+	compiled, err := regexp.Compile(flags + re.Pattern)
+	if err != nil {
+		return false
+	}
+	return anyElement(value, func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && compiled.MatchString(s)
+	})
+}
+
+// anyElement reports if fn holds for the value, or for one of its elements when it is an array
+func anyElement(value interface{}, fn func(v interface{}) bool) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, e := range list {
+			if fn(e) {
+				return true
+			}
+		}
+		return false
+	}
+	return fn(value)
+}
+
+func compareMatches(operator string, c int) bool {
+	switch operator {
+	case "$gt":
+		return c > 0
+	case "$gte":
+		return c >= 0
+	case "$lt":
+		return c < 0
+	}
+	return c <= 0
+}
+
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil:
+		return false
+	}
+	c, ok := compareValues(v, 0)
+	return !ok || c != 0
+}
+
+// typeRank orders values of different types like mongo does when sorting
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int, int32, int64, float64:
+		return 2
+	case string:
+		return 3
+	case bson.M:
+		return 4
+	case []interface{}:
+		return 5
+	case []byte, bson.Binary:
+		return 6
+	case bson.ObjectId:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	case bson.MongoTimestamp:
+		return 10
+	case bson.RegEx:
+		return 11
+	}
+	return 12
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+/*
+compareValues compares two values of the same type bracket.
+ok is false when they are of different brackets, which mongo never matches with $gt and alike.
+*/
+func compareValues(a interface{}, b interface{}) (c int, ok bool) {
+	if typeRank(a) != typeRank(b) {
+		return 0, false
+	}
+	switch av := a.(type) {
+	case nil:
+		return 0, true
+	case string:
+		return strings.Compare(av, b.(string)), true
+	case bson.ObjectId:
+		return strings.Compare(string(av), string(b.(bson.ObjectId))), true
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0, true
+		case bv:
+			return -1, true
+		}
+		return 1, true
+	case time.Time:
+		bv := b.(time.Time)
+		switch {
+		case av.Before(bv):
+			return -1, true
+		case av.After(bv):
+			return 1, true
+		}
+		return 0, true
+	case bson.MongoTimestamp:
+		return compareFloats(float64(av), float64(b.(bson.MongoTimestamp))), true
+	}
+	if typeRank(a) == 2 {
+		return compareFloats(toFloat(a), toFloat(b)), true
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, true
+	}
+	return 0, false
+}
+
+func compareFloats(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func valuesEqual(a interface{}, b interface{}) bool {
+	c, ok := compareValues(a, b)
+	return ok && c == 0
+}
+
+/*
+sortDocuments sorts documents by fields in the syntax of mgo's Query.Sort,
+eg. "name" for ascending and "-age" for descending order. The sort is stable,
+so documents which compare equal stay in the order they were inserted.
+*/
+func sortDocuments(docs []bson.M, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i int, j int) bool {
+		for _, field := range fields {
+			desc := strings.HasPrefix(field, "-")
+			path := strings.TrimLeft(field, "+-")
+			a, _ := lookupPath(docs[i], path)
+			b, _ := lookupPath(docs[j], path)
+			c := compareForSort(a, b)
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != desc
+		}
+		return false
+	})
+}
+
+// compareForSort orders any two values, by their type first
+func compareForSort(a interface{}, b interface{}) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return ra - rb
+	}
+	c, _ := compareValues(a, b)
+	return c
+}
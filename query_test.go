@@ -0,0 +1,91 @@
+package mgostore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_matchDocument(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	doc, _ := normalizeDocument(bson.M{
+		"name":    "Ada Lovelace",
+		"age":     36,
+		"score":   9.5,
+		"created": created,
+		"tags":    []string{"math", "poetry"},
+		"address": bson.M{"city": "London", "zip": "W1"},
+		"orders":  []bson.M{{"total": 10}, {"total": 25}},
+	})
+
+	cases := []struct {
+		filter  bson.M
+		matched bool
+	}{
+		{bson.M{}, true},
+		{bson.M{"name": "Ada Lovelace"}, true},
+		{bson.M{"name": "Ada"}, false},
+		{bson.M{"age": int64(36)}, true},
+		{bson.M{"age": 36.0}, true},
+		{bson.M{"age": bson.M{"$gt": 30, "$lte": 36}}, true},
+		{bson.M{"age": bson.M{"$lt": 30}}, false},
+		{bson.M{"age": bson.M{"$gt": "30"}}, false},
+		{bson.M{"score": bson.M{"$gte": 9}}, true},
+		{bson.M{"created": bson.M{"$lt": created.Add(time.Hour)}}, true},
+		{bson.M{"age": bson.M{"$ne": 36}}, false},
+		{bson.M{"age": bson.M{"$in": []int{1, 36}}}, true},
+		{bson.M{"age": bson.M{"$nin": []int{1, 36}}}, false},
+		{bson.M{"tags": "math"}, true},
+		{bson.M{"tags": []string{"math", "poetry"}}, true},
+		{bson.M{"tags": bson.M{"$all": []string{"poetry", "math"}}}, true},
+		{bson.M{"tags": bson.M{"$all": []string{"poetry", "music"}}}, false},
+		{bson.M{"tags": bson.M{"$size": 2}}, true},
+		{bson.M{"tags": bson.M{"$in": []string{"music", "poetry"}}}, true},
+		{bson.M{"address.city": "London"}, true},
+		{bson.M{"address": bson.M{"city": "London", "zip": "W1"}}, true},
+		{bson.M{"orders.total": 25}, true},
+		{bson.M{"orders": bson.M{"$elemMatch": bson.M{"total": bson.M{"$gt": 20}}}}, true},
+		{bson.M{"orders": bson.M{"$elemMatch": bson.M{"total": bson.M{"$gt": 30}}}}, false},
+		{bson.M{"missing": nil}, true},
+		{bson.M{"missing": bson.M{"$exists": false}}, true},
+		{bson.M{"name": bson.M{"$exists": true}}, true},
+		{bson.M{"name": bson.M{"$regex": "^ada", "$options": "i"}}, true},
+		{bson.M{"name": bson.RegEx{Pattern: "Love"}}, true},
+		{bson.M{"age": bson.M{"$not": bson.M{"$gt": 40}}}, true},
+		{bson.M{"$or": []bson.M{{"age": 1}, {"name": "Ada Lovelace"}}}, true},
+		{bson.M{"$and": []bson.M{{"age": 36}, {"name": "Ada"}}}, false},
+		{bson.M{"$nor": []bson.M{{"age": 1}, {"name": "Ada"}}}, true},
+	}
+	for _, c := range cases {
+		filter, err := normalizeDocument(c.filter)
+		assert.Nil(t, err)
+		matched, err := matchDocument(doc, filter)
+		assert.Nil(t, err)
+		assert.Equal(t, c.matched, matched, "Unexpected result for %v", c.filter)
+	}
+
+	t.Log("When an operator is not supported")
+	filter, _ := normalizeDocument(bson.M{"$where": "this.age > 1"})
+	_, err := matchDocument(doc, filter)
+	assert.Equal(t, ErrUnsupportedOperator, err)
+	filter, _ = normalizeDocument(bson.M{"name": bson.M{"$text": "Ada"}})
+	_, err = matchDocument(doc, filter)
+	assert.Equal(t, ErrUnsupportedOperator, err)
+}
+
+func Test_sortDocuments(t *testing.T) {
+	docs := []bson.M{
+		{"name": "b", "age": 2},
+		{"name": "a", "age": 2},
+		{"name": "c", "age": 1},
+		{"name": "d"},
+	}
+	sortDocuments(docs, []string{"-age", "name"})
+	var names []string
+	for _, doc := range docs {
+		names = append(names, doc["name"].(string))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names, "Expected missing fields to sort lowest")
+}
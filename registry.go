@@ -0,0 +1,216 @@
+package mgostore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+/*
+registryEntry holds the master session of one connection identity.
+The entry is registered before dialing so that concurrent callers with the same
+identity wait for the one dial instead of dialing themselves.
+*/
+type registryEntry struct {
+	// Servers of the connection, without credentials
+	servers   string
+	poolLimit int
+	// closed once dialing has finished, session, credentials and err must not be read before
+	ready   chan struct{}
+	session *mgo.Session
+	// hash of the credentials the session was dialled with
+	credentials string
+	err         error
+
+	// guards copying the session against closing it, set once the session was closed
+	sessionMux sync.RWMutex
+	closed     bool
+
+	// last connection or authentication error of a Ping or an operation, guarded by mux
+	mux         sync.Mutex
+	lastErr     error
+	lastErrTime time.Time
+}
+
+/*
+connectionKey returns the identity of the connection a config dials.
+Configs share a master session only when everything which affects dialing is equal.
+*/
+func connectionKey(config *MongoConfig) string {
+	servers, _ := splitServersUserInfo(config.Servers)
+	return fmt.Sprintf("servers=%s ssl=%t timeout=%s pool=%d %s %s",
+		servers,
+		config.IsSSL || config.TLS != nil,
+		config.Timeout,
+		config.PoolLimit,
+		tlsKey(config.TLS),
+		credentialsKey(config))
+}
+
+/*
+fetchRegistryEntry returns the registry entry of the config, dialing it on first use.
+The credentials are only resolved for dialing. A failed dial is not kept in the registry,
+so the next call resolves the credentials and dials again.
+*/
+func fetchRegistryEntry(config *MongoConfig) (*registryEntry, error) {
+	key := connectionKey(config)
+
+	registryMux.Lock()
+	e, ok := registry[key]
+	if ok {
+		registryMux.Unlock()
+		<-e.ready
+		return e, e.err
+	}
+	e = newRegistryEntry(config)
+	registry[key] = e
+	registryMux.Unlock()
+
+	// Dial without holding the lock, so that other connections are not blocked
+	e.dial(config)
+	if e.err != nil {
+		registryMux.Lock()
+		if registry[key] == e {
+			delete(registry, key)
+		}
+		registryMux.Unlock()
+	}
+	return e, e.err
+}
+
+func newRegistryEntry(config *MongoConfig) *registryEntry {
+	servers, _ := splitServersUserInfo(config.Servers)
+	return &registryEntry{servers: servers, poolLimit: config.PoolLimit, ready: make(chan struct{})}
+}
+
+// dial resolves the credentials of the config and dials the master session
+func (e *registryEntry) dial(config *MongoConfig) {
+	defer close(e.ready)
+	creds, err := resolveCredentials(config)
+	if err != nil {
+		e.err = err
+		return
+	}
+	e.credentials = credentialsHash(creds.Username, creds.Password)
+	e.session, e.err = dial(config, creds)
+}
+
+/*
+RefreshCredentials resolves the credentials of the config again, eg. after a password rotation.
+When they changed, a new master session is dialled with them and replaces the current one,
+which is closed once pending copies of it are done. Sessions already copied from it keep working until they are closed.
+On errors the current master session is kept.
+Operations refresh the credentials by themselves when the server rejects them.
+*/
+func RefreshCredentials(config *MongoConfig) error {
+	key := connectionKey(config)
+	registryMux.Lock()
+	old, ok := registry[key]
+	registryMux.Unlock()
+	if !ok {
+		// Dialled with the current credentials on first use
+		return nil
+	}
+	<-old.ready
+	creds, err := resolveCredentials(config)
+	if err != nil {
+		return err
+	}
+	if old.err == nil && old.credentials == credentialsHash(creds.Username, creds.Password) {
+		return nil
+	}
+	e := newRegistryEntry(config)
+	e.dial(config)
+	if e.err != nil {
+		return e.err
+	}
+	replaceEntry(key, old, e)
+	return nil
+}
+
+/*
+replaceEntry registers e in place of old and closes old, or closes e when the entry was
+refreshed or closed concurrently. The swap happens under the registry lock, so new copies are
+taken from e only. old is closed once the copies which are taken from it at that moment are done.
+*/
+func replaceEntry(key string, old *registryEntry, e *registryEntry) bool {
+	registryMux.Lock()
+	if registry[key] != old {
+		registryMux.Unlock()
+		e.close()
+		return false
+	}
+	registry[key] = e
+	deleteCircuitBreakers(key)
+	registryMux.Unlock()
+	old.close()
+	return true
+}
+
+/*
+Close closes the master session of the config and removes it and the circuit breakers of the connection from the registry.
+Sessions already copied from it keep working until they are closed.
+The next operation with the config dials a new connection.
+The error is always nil, it is kept for compatibility.
+*/
+func Close(config *MongoConfig) error {
+	key := connectionKey(config)
+	registryMux.Lock()
+	e, ok := registry[key]
+	delete(registry, key)
+	deleteCircuitBreakers(key)
+	registryMux.Unlock()
+	if ok {
+		e.close()
+	}
+	return nil
+}
+
+/*
+CloseAll closes all master sessions and removes all circuit breakers, eg. for a graceful shutdown.
+*/
+func CloseAll() {
+	registryMux.Lock()
+	entries := registry
+	registry = make(map[string]*registryEntry)
+	breakers = make(map[breakerKey]*circuitBreaker)
+	registryMux.Unlock()
+	for _, e := range entries {
+		e.close()
+	}
+}
+
+/*
+copySession returns a copy of the master session, or false when it was closed already.
+Entries are removed from the registry before they are closed, so fetching the entry again
+returns the one replacing it.
+*/
+func (e *registryEntry) copySession() (*mgo.Session, bool) {
+	e.sessionMux.RLock()
+	defer e.sessionMux.RUnlock()
+	if e.closed {
+		return nil, false
+	}
+	return e.session.Copy(), true
+}
+
+// close waits for a pending dial and for pending copies and closes the master session
+func (e *registryEntry) close() {
+	<-e.ready
+	e.sessionMux.Lock()
+	defer e.sessionMux.Unlock()
+	if e.session != nil && !e.closed {
+		e.session.Close()
+	}
+	e.closed = true
+}
+
+// setLastError records the error of a Ping or an operation
+func (e *registryEntry) setLastError(err error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.lastErr = err
+	e.lastErrTime = time.Now()
+}
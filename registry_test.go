@@ -0,0 +1,171 @@
+package mgostore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_connectionKey(t *testing.T) {
+	config := &MongoConfig{Servers: "localhost", Timeout: time.Second}
+	assert.Equal(t, testConnectionKey(config), testConnectionKey(&MongoConfig{Servers: "localhost", Timeout: time.Second, DBName: "other"}),
+		"Expected configs for other DBs to share the connection")
+	assert.NotEqual(t, testConnectionKey(config), testConnectionKey(&MongoConfig{Servers: "localhost", Timeout: time.Second, IsSSL: true}),
+		"Expected SSL to be part of the key")
+	assert.NotEqual(t, testConnectionKey(config), testConnectionKey(&MongoConfig{Servers: "localhost", Timeout: 2 * time.Second}),
+		"Expected the timeout to be part of the key")
+	assert.NotEqual(t, testConnectionKey(config), testConnectionKey(&MongoConfig{Servers: "otherhost", Timeout: time.Second}),
+		"Expected the servers to be part of the key")
+}
+
+func Test_masterSessionFailedDial(t *testing.T) {
+	config := &MongoConfig{Servers: "invalid_server", Timeout: 10 * time.Millisecond}
+
+	t.Log("When the same config is dialed concurrently")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := newSession(config)
+			assert.NotNil(t, err, "Expected not reachable servers error")
+		}()
+	}
+	wg.Wait()
+
+	registryMux.Lock()
+	_, ok := registry[testConnectionKey(config)]
+	registryMux.Unlock()
+	assert.False(t, ok, "Expected the failed dial to not be kept in the registry")
+}
+
+func TestClose(t *testing.T) {
+	config := &MongoConfig{Servers: "closed_server"}
+	e := &registryEntry{ready: make(chan struct{})}
+	close(e.ready)
+	registryMux.Lock()
+	registry[testConnectionKey(config)] = e
+	registryMux.Unlock()
+
+	Close(config)
+	registryMux.Lock()
+	_, ok := registry[testConnectionKey(config)]
+	registryMux.Unlock()
+	assert.False(t, ok, "Expected the entry to be removed")
+
+	t.Log("When all connections are closed")
+	registryMux.Lock()
+	registry[testConnectionKey(config)] = e
+	registryMux.Unlock()
+	CloseAll()
+	registryMux.Lock()
+	assert.Equal(t, 0, len(registry), "Expected the registry to be empty")
+	registryMux.Unlock()
+}
+
+// testConnectionKey returns the key of the config in the registry
+func testConnectionKey(config *MongoConfig) string {
+	return connectionKey(config)
+}
+
+// countingCredentials provides a password and counts how often it was asked.
+// The state is held by a pointer, as the value of providers is part of the connection key
+type countingCredentials struct {
+	*credentialsState
+}
+
+type credentialsState struct {
+	password string
+	calls    int
+}
+
+func (cc countingCredentials) Credentials() (*Credentials, error) {
+	cc.calls++
+	return &Credentials{Password: cc.password}, nil
+}
+
+func TestRefreshCredentials(t *testing.T) {
+	provider := countingCredentials{&credentialsState{password: "old"}}
+	config := &MongoConfig{Servers: "refreshed_server", Timeout: 10 * time.Millisecond, CredentialProvider: provider}
+	testConnectionKey(config)
+	assert.Equal(t, 0, provider.calls, "Expected the credentials to not be resolved for the key")
+
+	e := &registryEntry{ready: make(chan struct{}), credentials: credentialsHash("", "old")}
+	close(e.ready)
+	registryMux.Lock()
+	registry[testConnectionKey(config)] = e
+	registryMux.Unlock()
+	defer Close(config)
+
+	t.Log("When the credentials have not changed")
+	assert.Nil(t, RefreshCredentials(config))
+	assert.Equal(t, 1, provider.calls)
+	registryMux.Lock()
+	assert.True(t, registry[testConnectionKey(config)] == e, "Expected the entry to be kept")
+	registryMux.Unlock()
+
+	t.Log("When the new credentials cannot be dialled")
+	provider.password = "new"
+	assert.NotNil(t, RefreshCredentials(config), "Expected not reachable servers error")
+	registryMux.Lock()
+	assert.True(t, registry[testConnectionKey(config)] == e, "Expected the entry to be kept on errors")
+	registryMux.Unlock()
+
+	t.Log("When the config was not dialled yet")
+	other := countingCredentials{&credentialsState{password: "other"}}
+	assert.Nil(t, RefreshCredentials(&MongoConfig{Servers: "refreshed_server", CredentialProvider: other}))
+	assert.Equal(t, 0, other.calls, "Expected the credentials to be resolved when dialling")
+}
+
+func Test_registryEntryClose(t *testing.T) {
+	config := &MongoConfig{Servers: "closed_server", Timeout: 10 * time.Millisecond}
+	e := &registryEntry{ready: make(chan struct{})}
+	close(e.ready)
+	registryMux.Lock()
+	registry[testConnectionKey(config)] = e
+	registryMux.Unlock()
+
+	t.Log("When the entry was closed after it was fetched")
+	Close(config)
+	_, ok := e.copySession()
+	assert.False(t, ok, "Expected no copy of a closed session")
+	registryMux.Lock()
+	_, ok = registry[testConnectionKey(config)]
+	registryMux.Unlock()
+	assert.False(t, ok, "Expected the entry to be removed before it was closed")
+}
+
+func Test_replaceEntry(t *testing.T) {
+	key := "replaced_server"
+	old := &registryEntry{ready: make(chan struct{})}
+	close(old.ready)
+	registryMux.Lock()
+	registry[key] = old
+	registryMux.Unlock()
+	defer func() {
+		registryMux.Lock()
+		delete(registry, key)
+		registryMux.Unlock()
+	}()
+
+	t.Log("When the credentials were refreshed")
+	e := &registryEntry{ready: make(chan struct{})}
+	close(e.ready)
+	assert.True(t, replaceEntry(key, old, e))
+	registryMux.Lock()
+	assert.True(t, registry[key] == e, "Expected the new entry to be registered")
+	registryMux.Unlock()
+	assert.True(t, old.closed, "Expected the old entry to be closed")
+	assert.False(t, e.closed)
+
+	t.Log("When the entry was refreshed concurrently")
+	other := &registryEntry{ready: make(chan struct{})}
+	close(other.ready)
+	assert.False(t, replaceEntry(key, old, other))
+	registryMux.Lock()
+	assert.True(t, registry[key] == e, "Expected the current entry to be kept")
+	registryMux.Unlock()
+	assert.True(t, other.closed, "Expected the unused entry to be closed")
+}
@@ -0,0 +1,135 @@
+package mgostore
+
+import (
+	"context"
+	"reflect"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ModelPointer is satisfied by *T when the model T implements Model on its pointer
+type ModelPointer[T any] interface {
+	*T
+	Model
+}
+
+/*
+Repository offers the CRUD and query operations for one model type T, with compile time typing.
+The collection and DB config are derived from T, so no slice type with its own
+CollectionName and DBConfig methods is needed.
+
+	users := mgostore.NewRepository[User](nil)
+	user, err := users.FindByID(id)
+	adults, err := users.FindMany(bson.M{"age": bson.M{"$gte": 18}}, mgostore.Sort("name"))
+*/
+type Repository[T any, P ModelPointer[T]] struct {
+	store Store
+}
+
+/*
+NewRepository returns the repository of the model T on the store.
+A nil store defaults to the MongoStore.
+*/
+func NewRepository[T any, P ModelPointer[T]](store Store) *Repository[T, P] {
+	if store == nil {
+		store = MongoStore{}
+	}
+	return &Repository[T, P]{store: store}
+}
+
+// CollectionName returns the collection of the model T
+func (r *Repository[T, P]) CollectionName() string {
+	return P(new(T)).CollectionName()
+}
+
+// DBConfig returns the DB config of the model T
+func (r *Repository[T, P]) DBConfig() *MongoConfig {
+	return P(new(T)).DBConfig()
+}
+
+func (r *Repository[T, P]) Create(m *T, opts ...Option) error {
+	return r.store.Create(P(m), opts...)
+}
+
+func (r *Repository[T, P]) Update(m *T, opts ...Option) error {
+	return r.store.Update(P(m), opts...)
+}
+
+func (r *Repository[T, P]) Destroy(m *T, opts ...Option) error {
+	return r.store.Destroy(P(m), opts...)
+}
+
+/*
+FindByID returns the model with the ID, or ErrRecordNotFound.
+For models with a bson.ObjectId the ID may also be given in its hex form.
+*/
+func (r *Repository[T, P]) FindByID(id interface{}, opts ...Option) (*T, error) {
+	m := new(T)
+	f := reflect.ValueOf(m).Elem().FieldByName("ID")
+	if hex, ok := id.(string); ok && f.IsValid() && f.Type() == reflect.TypeOf(bson.ObjectId("")) {
+		if !bson.IsObjectIdHex(hex) {
+			return nil, ErrInvalidId
+		}
+		id = bson.ObjectIdHex(hex)
+	}
+	idVal := reflect.ValueOf(id)
+	if !f.IsValid() || !idVal.IsValid() || idVal.Kind() != f.Kind() || !idVal.Type().ConvertibleTo(f.Type()) {
+		return nil, ErrInvalidId
+	}
+	f.Set(idVal.Convert(f.Type()))
+	if err := r.store.Find(P(m), opts...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FindBy returns the first model which matches the where clause, or ErrRecordNotFound
+func (r *Repository[T, P]) FindBy(whereClause bson.M, opts ...Option) (*T, error) {
+	m := new(T)
+	if err := r.store.FindBy(whereClause, P(m), opts...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+/*
+FindMany returns the models which match the where clause.
+Unlike FindManyWithOptions, the encrypted fields of the models are decrypted.
+*/
+func (r *Repository[T, P]) FindMany(whereClause bson.M, opts ...Option) ([]T, error) {
+	var models modelSlice[T, P]
+	if err := r.store.FindMany(whereClause, &models, opts...); err != nil {
+		return nil, err
+	}
+	for i := range models {
+		if err := decryptFields(P(&models[i])); err != nil {
+			return nil, err
+		}
+	}
+	return []T(models), nil
+}
+
+func (r *Repository[T, P]) Count(whereClause bson.M, opts ...Option) (int, error) {
+	return r.store.Count(whereClause, P(new(T)), opts...)
+}
+
+// modelSlice is the Models of the model T, which FindMany decodes into
+type modelSlice[T any, P ModelPointer[T]] []T
+
+func (s *modelSlice[T, P]) CollectionName() string {
+	return P(new(T)).CollectionName()
+}
+
+func (s *modelSlice[T, P]) DBConfig() *MongoConfig {
+	return P(new(T)).DBConfig()
+}
+
+// Subscribe is Subscribe for the capped collection of the model T
+func (r *Repository[T, P]) Subscribe(ctx context.Context, whereClause bson.M, opts SubscribeOptions) *Subscription[T] {
+	return Subscribe[T, P](ctx, whereClause, opts)
+}
+
+// Watch is Watch for the collection of the model T
+func (r *Repository[T, P]) Watch(ctx context.Context, opts WatchOptions) *ChangeStream[T] {
+	return Watch[T, P](ctx, opts)
+}
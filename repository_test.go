@@ -0,0 +1,57 @@
+package mgostore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRepository(t *testing.T) {
+	setTestEnvVars()
+	repo := NewRepository[mockModel](NewMemoryStore())
+	assert.Equal(t, "mock_models", repo.CollectionName())
+	assert.Equal(t, "mgostore_test", repo.DBConfig().DBName)
+
+	t.Log("When models are created")
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, repo.Create(&mockModel{NumField: i, EncryptedField1: "crypto text"}))
+	}
+	n, err := repo.Count(bson.M{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	t.Log("When models are queried")
+	models, err := repo.FindMany(bson.M{"num_field": bson.M{"$gt": 1}}, Sort("-num_field"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(models))
+	assert.Equal(t, 3, models[0].NumField)
+	assert.Equal(t, "crypto text", models[0].EncryptedField1, "Expected the models to be decrypted")
+
+	m, err := repo.FindBy(bson.M{"num_field": 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, m.NumField)
+
+	t.Log("When a model is found by its ID")
+	found, err := repo.FindByID(m.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, m.ID, found.ID)
+	found, err = repo.FindByID(m.ID.Hex())
+	assert.Nil(t, err, "Expected the hex form of the ID to be accepted")
+	assert.Equal(t, m.ID, found.ID)
+	_, err = repo.FindByID("not hex")
+	assert.Equal(t, ErrInvalidId, err)
+	_, err = repo.FindByID(42)
+	assert.Equal(t, ErrInvalidId, err)
+	_, err = repo.FindByID(bson.NewObjectId())
+	assert.Equal(t, ErrRecordNotFound, err)
+
+	t.Log("When a model is updated and destroyed")
+	found.PlainTextField = "plain text"
+	assert.Nil(t, repo.Update(found))
+	m, _ = repo.FindBy(bson.M{"plain_text_field": "plain text"})
+	assert.Equal(t, found.ID, m.ID)
+	assert.Nil(t, repo.Destroy(m))
+	n, _ = repo.Count(nil)
+	assert.Equal(t, 2, n)
+}
@@ -0,0 +1,93 @@
+package mgostore
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+/*
+RetryPolicy configures how operations are retried after transient errors,
+eg. during replica set elections.
+Only idempotent operations are retried. Inserts are only retried as the document has an ID,
+which Create always generates, so that a retried insert cannot store the document twice.
+*/
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one
+	MaxAttempts int
+	// Backoff before the first retry. It doubles with every further retry
+	InitialBackoff time.Duration
+	// Upper bound of the backoff
+	MaxBackoff time.Duration
+}
+
+// Server error codes which are worth retrying, as the cluster is changing its primary or shutting down
+var transientErrorCodes = map[int]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	9001:  true, // SocketException
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+/*
+IsTransient reports if an error is caused by a temporary state of the cluster or the network,
+so that retrying the operation later may succeed. All other errors are permanent.
+*/
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch e := err.(type) {
+	case *ConnectionError:
+		return IsTransient(e.Err)
+	case *TimeoutError:
+		return IsTransient(e.Err)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	switch e := err.(type) {
+	case *mgo.QueryError:
+		return transientErrorCodes[e.Code] || strings.HasPrefix(e.Message, "not master")
+	case *mgo.LastError:
+		return transientErrorCodes[e.Code] || strings.HasPrefix(e.Err, "not master")
+	}
+	switch err.Error() {
+	case "no reachable servers", "Closed explicitly", "EOF":
+		return true
+	}
+	return false
+}
+
+/*
+backoff returns the pause before the given retry, starting at 1 for the first retry.
+It grows exponentially and is jittered, so that clients do not retry in lockstep.
+*/
+func (rp *RetryPolicy) backoff(retry int) time.Duration {
+	d := rp.InitialBackoff
+	for i := 1; i < retry && (rp.MaxBackoff <= 0 || d < rp.MaxBackoff); i++ {
+		d *= 2
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// Equal jitter: half of the backoff is fixed, the other half random
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
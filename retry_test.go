@@ -0,0 +1,73 @@
+package mgostore
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func TestIsTransient(t *testing.T) {
+	assert.False(t, IsTransient(nil))
+	assert.True(t, IsTransient(io.EOF), "Expected EOF to be transient")
+	assert.True(t, IsTransient(errors.New("no reachable servers")), "Expected no reachable servers to be transient")
+	assert.True(t, IsTransient(&net.OpError{Op: "read", Err: errors.New("connection reset")}), "Expected network errors to be transient")
+	assert.True(t, IsTransient(&mgo.QueryError{Code: 10107, Message: "not master"}), "Expected not master to be transient")
+	assert.True(t, IsTransient(&mgo.LastError{Code: 189, Err: "primary stepped down"}), "Expected stepped down to be transient")
+	assert.True(t, IsTransient(&ConnectionError{Err: io.EOF}), "Expected wrapped errors to be transient")
+
+	assert.False(t, IsTransient(mgo.ErrNotFound), "Expected not found to be permanent")
+	assert.False(t, IsTransient(&mgo.LastError{Code: 11000, Err: "E11000 duplicate key error"}), "Expected duplicate key to be permanent")
+	assert.False(t, IsTransient(ErrCiphertextMismatch), "Expected decryption errors to be permanent")
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	rp := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for i := 0; i < 10; i++ {
+		d := rp.backoff(1)
+		assert.True(t, d >= 50*time.Millisecond && d <= 100*time.Millisecond, "Expected the first backoff to be jittered around the initial backoff")
+		d = rp.backoff(2)
+		assert.True(t, d >= 100*time.Millisecond && d <= 200*time.Millisecond, "Expected the backoff to double")
+		d = rp.backoff(5)
+		assert.True(t, d >= 150*time.Millisecond && d <= 300*time.Millisecond, "Expected the backoff to be capped")
+	}
+	assert.Equal(t, time.Duration(0), (&RetryPolicy{}).backoff(1), "Expected no backoff when none is configured")
+}
+
+func Test_operationRetries(t *testing.T) {
+	config := &MongoConfig{
+		Servers:     "invalid_server",
+		Timeout:     10 * time.Millisecond,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	called := false
+	fn := func(c *mgo.Collection) error {
+		called = true
+		return nil
+	}
+
+	t.Log("When an idempotent operation fails with a transient error")
+	op := newOperation(config, "mock_models", nil)
+	op.idempotent = true
+	err := op.run(fn)
+	assert.Equal(t, "no reachable servers", err.Error())
+	assert.Equal(t, 3, op.attempt, "Expected all attempts to be made")
+	assert.False(t, called)
+
+	t.Log("When the operation is not idempotent")
+	op = newOperation(config, "mock_models", nil)
+	err = op.run(fn)
+	assert.Equal(t, "no reachable servers", err.Error())
+	assert.Equal(t, 1, op.attempt, "Expected no retries")
+
+	t.Log("When there is no retry policy")
+	config.RetryPolicy = nil
+	op = newOperation(config, "mock_models", nil)
+	op.idempotent = true
+	op.run(fn)
+	assert.Equal(t, 1, op.attempt, "Expected no retries")
+}
@@ -0,0 +1,206 @@
+package mgostore
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ValidationLevel is which documents the validator of a collection checks
+type ValidationLevel string
+
+const (
+	// ValidationStrict checks all inserts and updates
+	ValidationStrict ValidationLevel = "strict"
+	// ValidationModerate checks inserts and updates of documents which are valid already
+	ValidationModerate ValidationLevel = "moderate"
+	// ValidationOff checks nothing
+	ValidationOff ValidationLevel = "off"
+)
+
+var (
+	typeTime           = reflect.TypeOf(time.Time{})
+	typeObjectID       = reflect.TypeOf(bson.ObjectId(""))
+	typeMongoTimestamp = reflect.TypeOf(bson.MongoTimestamp(0))
+	typeBinary         = reflect.TypeOf(bson.Binary{})
+	typeRaw            = reflect.TypeOf(bson.Raw{})
+	typeDocElems       = reflect.TypeOf(bson.D{})
+	typeGetter         = reflect.TypeOf((*bson.Getter)(nil)).Elem()
+)
+
+/*
+JSONSchema returns the $jsonSchema of the documents of the model, generated from its fields and bson tags.
+Fields tagged with validate:"required" are required. Encrypted and hashed fields are strings.
+Pointers, slices, maps and interfaces may be null. Fields of types with their own GetBSON are not checked,
+neither are additional fields, so that documents may carry fields the model does not know.
+
+	type User struct {
+		ID    bson.ObjectId `bson:"_id,omitempty"`
+		Email string        `bson:"email" validate:"required"`
+		Age   int           `bson:"age,omitempty"`
+	}
+
+gives
+
+	{"bsonType": "object", "required": ["email"], "properties": {
+		"_id": {"bsonType": "objectId"}, "email": {"bsonType": "string"}, "age": {"bsonType": ["int", "long"]}}}
+*/
+func JSONSchema(m Model) bson.M {
+	return structSchema(reflect.TypeOf(m).Elem(), map[reflect.Type]bool{})
+}
+
+/*
+structSchema returns the schema of the documents of the struct type.
+visiting holds the struct types being generated, the values of recursive types are not checked.
+*/
+func structSchema(t reflect.Type, visiting map[reflect.Type]bool) bson.M {
+	if visiting[t] {
+		return bson.M{}
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	properties := bson.M{}
+	var required []string
+	addStructProperties(t, properties, &required, visiting)
+	schema := bson.M{"bsonType": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addStructProperties adds the properties of the exported fields of the struct type, including inlined structs
+func addStructProperties(t reflect.Type, properties bson.M, required *[]string, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("bson")
+		if (f.PkgPath != "" && !f.Anonymous) || tag == "-" {
+			continue
+		}
+		flags := strings.Split(tag, ",")[1:]
+		if hasFlag(flags, "inline") {
+			if f.Type.Kind() == reflect.Struct {
+				addStructProperties(f.Type, properties, required, visiting)
+			}
+			continue
+		}
+		name := fieldBSONName(f)
+		if isEncryptableType(f.Type) && (encryptionTag(f) != "" || f.Tag.Get("hash") != "") {
+			// Stored as the ciphertext or the hash
+			properties[name] = bson.M{"bsonType": "string"}
+		} else {
+			properties[name] = typeSchema(f.Type, visiting)
+		}
+		if hasFlag(strings.Split(f.Tag.Get("validate"), ","), "required") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// typeSchema returns the schema of the values of the type
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) bson.M {
+	if reflect.PtrTo(t).Implements(typeGetter) || t.Implements(typeGetter) {
+		return bson.M{}
+	}
+	switch t {
+	case typeTime:
+		return bson.M{"bsonType": "date"}
+	case typeObjectID:
+		return bson.M{"bsonType": "objectId"}
+	case typeMongoTimestamp:
+		return bson.M{"bsonType": "timestamp"}
+	case typeBinary:
+		return bson.M{"bsonType": "binData"}
+	case typeRaw:
+		return bson.M{}
+	case typeDocElems:
+		return nullable(bson.M{"bsonType": "object"})
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return bson.M{"bsonType": "string"}
+	case reflect.Bool:
+		return bson.M{"bsonType": "bool"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// The encoding depends on the value and the minsize flag
+		return bson.M{"bsonType": []string{"int", "long"}}
+	case reflect.Float32, reflect.Float64:
+		// Integers written by other services decode into floats as well
+		return bson.M{"bsonType": []string{"double", "int", "long"}}
+	case reflect.Struct:
+		return structSchema(t, visiting)
+	case reflect.Ptr:
+		return nullable(typeSchema(t.Elem(), visiting))
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nullable(bson.M{"bsonType": "binData"})
+		}
+		return nullable(bson.M{"bsonType": "array", "items": typeSchema(t.Elem(), visiting)})
+	case reflect.Map:
+		return nullable(bson.M{"bsonType": "object"})
+	}
+	// Interfaces hold any value
+	return bson.M{}
+}
+
+// nullable allows null besides the bson types of the schema
+func nullable(schema bson.M) bson.M {
+	switch bsonType := schema["bsonType"].(type) {
+	case string:
+		schema["bsonType"] = []string{bsonType, "null"}
+	case []string:
+		schema["bsonType"] = append(bsonType, "null")
+	}
+	return schema
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.TrimSpace(f) == flag {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ApplySchema sets the JSONSchema of the model as the validator of its collection, with the validation level,
+which defaults to ValidationStrict when empty. The collection is created when it does not exist. Writes of documents which fail validation
+return a ValidationError.
+
+	err := mgostore.ApplySchema(&User{}, mgostore.ValidationModerate)
+*/
+func ApplySchema(m Model, level ValidationLevel, opts ...Option) error {
+	if level == "" {
+		level = ValidationStrict
+	}
+	validator := bson.M{"$jsonSchema": JSONSchema(m)}
+	op := newModelOperation("apply_schema", m, nil, opts)
+	op.idempotent = true
+	return op.run(func(c *mgo.Collection) error {
+		collMod := collModCommand(c.Name, validator, level)
+		err := c.Database.Run(collMod, nil)
+		if !hasErrorCode(err, namespaceNotFoundCode) {
+			return err
+		}
+		err = createCollection(c, CollectionOptions{Validator: validator, ValidationLevel: level})
+		if err == ErrCollectionExists {
+			// Created concurrently
+			return c.Database.Run(collMod, nil)
+		}
+		return err
+	})
+}
+
+// collModCommand returns the command which sets the validator of the named collection
+func collModCommand(name string, validator bson.M, level ValidationLevel) bson.D {
+	return bson.D{
+		{Name: "collMod", Value: name},
+		{Name: "validator", Value: validator},
+		{Name: "validationLevel", Value: string(level)},
+	}
+}
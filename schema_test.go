@@ -0,0 +1,111 @@
+package mgostore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type schemaAddress struct {
+	City string `bson:"city" validate:"required"`
+}
+
+type schemaTimestamps struct {
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type schemaNode struct {
+	Name     string        `bson:"name"`
+	Children []*schemaNode `bson:"children"`
+}
+
+type schemaModel struct {
+	ID               bson.ObjectId  `bson:"_id,omitempty"`
+	Email            string         `bson:"email" validate:"required,email"`
+	Password         string         `bson:"password" hash:"bcrypt"`
+	SSN              string         `bson:"ssn" encrypt:"aes"`
+	Token            Secret         `bson:"token"`
+	Age              int            `bson:"age,omitempty"`
+	Score            float64        `bson:"score"`
+	Active           bool           `bson:"active"`
+	Tags             []string       `bson:"tags"`
+	Avatar           []byte         `bson:"avatar"`
+	Address          schemaAddress  `bson:"address"`
+	Previous         *schemaAddress `bson:"previous"`
+	Extra            bson.M         `bson:"extra"`
+	Any              interface{}    `bson:"any"`
+	Tree             schemaNode     `bson:"tree"`
+	Orders           []schemaOrder  `bson:"-"`
+	schemaTimestamps `bson:",inline"`
+	internal         string
+}
+
+func (m *schemaModel) CollectionName() string { return "schema_models" }
+
+func (m *schemaModel) DBConfig() *MongoConfig { return testMongoConfig() }
+
+type schemaOrder struct{}
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema(&schemaModel{})
+	assert.Equal(t, "object", schema["bsonType"])
+	assert.Equal(t, []string{"email"}, schema["required"], "Expected the fields tagged as required")
+
+	properties := schema["properties"].(bson.M)
+	assert.Equal(t, bson.M{"bsonType": "objectId"}, properties["_id"])
+	assert.Equal(t, bson.M{"bsonType": "string"}, properties["email"])
+	assert.Equal(t, bson.M{"bsonType": "string"}, properties["password"], "Expected hashed fields to be strings")
+	assert.Equal(t, bson.M{"bsonType": "string"}, properties["ssn"], "Expected encrypted fields to be strings")
+	assert.Equal(t, bson.M{"bsonType": "string"}, properties["token"], "Expected secrets to be strings")
+	assert.Equal(t, bson.M{"bsonType": []string{"int", "long"}}, properties["age"])
+	assert.Equal(t, bson.M{"bsonType": []string{"double", "int", "long"}}, properties["score"])
+	assert.Equal(t, bson.M{"bsonType": "bool"}, properties["active"])
+	assert.Equal(t, bson.M{"bsonType": []string{"array", "null"}, "items": bson.M{"bsonType": "string"}}, properties["tags"])
+	assert.Equal(t, bson.M{"bsonType": []string{"binData", "null"}}, properties["avatar"])
+	assert.Equal(t, bson.M{
+		"bsonType":   "object",
+		"required":   []string{"city"},
+		"properties": bson.M{"city": bson.M{"bsonType": "string"}},
+	}, properties["address"], "Expected nested documents to be checked")
+	assert.Equal(t, []string{"object", "null"}, properties["previous"].(bson.M)["bsonType"], "Expected pointers to be nullable")
+	assert.Equal(t, bson.M{"bsonType": []string{"object", "null"}}, properties["extra"])
+	assert.Equal(t, bson.M{}, properties["any"], "Expected interfaces not to be checked")
+	assert.Equal(t, bson.M{"bsonType": "date"}, properties["created_at"], "Expected inlined fields")
+	assert.NotContains(t, properties, "orders", "Expected fields which are not stored to be skipped")
+	assert.NotContains(t, properties, "internal", "Expected unexported fields to be skipped")
+
+	t.Log("When the type is recursive")
+	tree := properties["tree"].(bson.M)["properties"].(bson.M)
+	assert.Equal(t, bson.M{"bsonType": []string{"array", "null"}, "items": bson.M{}}, tree["children"],
+		"Expected the recursion not to be checked")
+}
+
+func Test_collModCommand(t *testing.T) {
+	validator := bson.M{"$jsonSchema": bson.M{"bsonType": "object"}}
+	assert.Equal(t, bson.D{
+		{Name: "collMod", Value: "users"},
+		{Name: "validator", Value: validator},
+		{Name: "validationLevel", Value: "moderate"},
+	}, collModCommand("users", validator, ValidationModerate))
+}
+
+func TestApplySchema(t *testing.T) {
+	setTestEnvVars()
+	t.Log("When connection can be established")
+	m := &schemaModel{}
+	s, _ := newSession(m.DBConfig())
+	tc := s.DB(m.DBConfig().DBName).C(m.CollectionName())
+	// Make sure to drop the entire collection after the test is run
+	defer DropCollection(m)
+
+	assert.Nil(t, ApplySchema(m, ValidationStrict), "Expected the collection to be created")
+	assert.Nil(t, ApplySchema(m, ValidationModerate), "Expected the validator to be updated")
+	assert.Nil(t, ApplySchema(m, ""), "Expected the level to default to strict")
+
+	err := tc.Insert(bson.M{"email": 42})
+	assert.True(t, errors.Is(wrapError(err), ErrValidation), "Expected an invalid document to be rejected")
+	assert.Nil(t, tc.Insert(bson.M{"email": "a@example.com", "address": bson.M{"city": "Berlin"}}))
+}
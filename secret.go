@@ -0,0 +1,41 @@
+package mgostore
+
+import "encoding/json"
+
+const redactedSecret = "[REDACTED]"
+
+/*
+Secret is a string field type which is stored encrypted, just like a string field
+with the tag `encrypt:"aes"`. An explicit encrypt tag on a Secret field selects the
+algorithm as it would for a string field.
+
+After being read from the DB a Secret holds the decrypted value, but it redacts itself
+when it is printed with fmt (%v, %s, %#v, ...) or marshalled to JSON, so that it does not
+end up in logs or responses by accident. Call Reveal to get the plain value.
+
+	type User struct {
+		ID     bson.ObjectId    `json:"id" bson:"_id,omitempty"`
+		APIKey mgostore.Secret `json:"api_key" bson:"api_key"`
+	}
+*/
+type Secret string
+
+// Reveal returns the plain value of the secret
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String redacts the secret when it is formatted
+func (s Secret) String() string {
+	return redactedSecret
+}
+
+// GoString redacts the secret when it is formatted with %#v
+func (s Secret) GoString() string {
+	return redactedSecret
+}
+
+// MarshalJSON redacts the secret in json responses
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redactedSecret)
+}
@@ -0,0 +1,35 @@
+package mgostore
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSecret(t *testing.T) {
+	s := Secret("shhhhhh")
+	assert.Equal(t, "shhhhhh", s.Reveal(), "Expected plain value when revealed")
+	assert.Equal(t, redactedSecret, fmt.Sprintf("%v", s), "Expected secret to be redacted with %v")
+	assert.Equal(t, redactedSecret, fmt.Sprintf("%s", s), "Expected secret to be redacted with %s")
+	assert.Equal(t, redactedSecret, fmt.Sprintf("%#v", s), "Expected secret to be redacted with %#v")
+
+	m := &mockModel{SecretField: s}
+	assert.NotContains(t, fmt.Sprintf("%+v", m), "shhhhhh", "Expected secret to be redacted within a model")
+	b, _ := json.Marshal(m)
+	assert.NotContains(t, string(b), "shhhhhh", "Expected secret to be redacted in json")
+	assert.Contains(t, string(b), `"secret_field":"[REDACTED]"`)
+}
+
+func TestSecretEncryption(t *testing.T) {
+	m := &mockModel{ID: bson.NewObjectId(), SecretField: "shhhhhh"}
+	err := encryptFields(m)
+	assert.Nil(t, err, "Expected no error")
+	assert.NotEqual(t, "shhhhhh", m.SecretField.Reveal(), "Expected secret field to be encrypted")
+
+	err = decryptFields(m)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, "shhhhhh", m.SecretField.Reveal(), "Expected secret field to be decrypted")
+}
@@ -0,0 +1,59 @@
+package mgostore
+
+import "gopkg.in/mgo.v2/bson"
+
+/*
+Store covers the CRUD, query and count operations on models.
+Services which take a Store instead of calling the package-level functions can be
+unit tested with a MemoryStore, without a running mongod.
+
+	type UserService struct {
+		Store mgostore.Store
+	}
+
+	service := &UserService{Store: mgostore.MongoStore{}}
+	testService := &UserService{Store: mgostore.NewMemoryStore()}
+*/
+type Store interface {
+	Create(m Model, opts ...Option) error
+	Update(m Model, opts ...Option) error
+	Find(m Model, opts ...Option) error
+	FindBy(whereClause bson.M, m Model, opts ...Option) error
+	// FindMany is FindManyWithOptions
+	FindMany(whereClause bson.M, models Models, opts ...Option) error
+	Destroy(m Model, opts ...Option) error
+	Count(whereClause bson.M, m Model, opts ...Option) (int, error)
+}
+
+// MongoStore is the Store backed by mongo, it calls the package-level functions
+type MongoStore struct{}
+
+var _ Store = MongoStore{}
+
+func (MongoStore) Create(m Model, opts ...Option) error {
+	return Create(m, opts...)
+}
+
+func (MongoStore) Update(m Model, opts ...Option) error {
+	return Update(m, opts...)
+}
+
+func (MongoStore) Find(m Model, opts ...Option) error {
+	return Find(m, opts...)
+}
+
+func (MongoStore) FindBy(whereClause bson.M, m Model, opts ...Option) error {
+	return FindBy(whereClause, m, opts...)
+}
+
+func (MongoStore) FindMany(whereClause bson.M, models Models, opts ...Option) error {
+	return FindManyWithOptions(whereClause, models, opts...)
+}
+
+func (MongoStore) Destroy(m Model, opts ...Option) error {
+	return Destroy(m, opts...)
+}
+
+func (MongoStore) Count(whereClause bson.M, m Model, opts ...Option) (int, error) {
+	return Count(whereClause, m, opts...)
+}
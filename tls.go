@@ -0,0 +1,79 @@
+package mgostore
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+/*
+buildTLSConfig returns the tls.Config to dial the servers with.
+A nil TLSConfig results in the defaults of crypto/tls.
+*/
+func buildTLSConfig(c *TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if c == nil {
+		return tlsConfig, nil
+	}
+	tlsConfig.ServerName = c.ServerName
+	tlsConfig.MinVersion = c.MinVersion
+	tlsConfig.InsecureSkipVerify = c.InsecureSkipVerify
+
+	caPEM, err := readPEM(c.CAFile, c.CAPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(caPEM) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, ErrInvalidCACertificates
+		}
+	}
+
+	certPEM, err := readPEM(c.CertFile, c.CertPEM)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := readPEM(c.KeyFile, c.KeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certPEM) > 0 || len(keyPEM) > 0 {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// readPEM returns the contents of the file, or the inline PEM when no file is given
+func readPEM(file string, inline []byte) ([]byte, error) {
+	if file == "" {
+		return inline, nil
+	}
+	return ioutil.ReadFile(file)
+}
+
+/*
+tlsKey returns the part of the connection key which identifies the TLS options.
+Certificates and keys are hashed, so that they are neither kept in the registry
+nor end up in logs.
+*/
+func tlsKey(c *TLSConfig) string {
+	if c == nil {
+		return "tls=default"
+	}
+	h := sha256.New()
+	for _, b := range [][]byte{
+		[]byte(c.CAFile), c.CAPEM,
+		[]byte(c.CertFile), []byte(c.KeyFile), c.CertPEM, c.KeyPEM,
+		[]byte(c.ServerName),
+	} {
+		fmt.Fprintf(h, "%d:%s", len(b), b)
+	}
+	return fmt.Sprintf("tls=%x min=%d insecure=%t", h.Sum(nil), c.MinVersion, c.InsecureSkipVerify)
+}
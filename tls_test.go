@@ -0,0 +1,95 @@
+package mgostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertificate returns a self signed PEM encoded certificate and its private key
+func testCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mgostore test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func Test_buildTLSConfig(t *testing.T) {
+	t.Log("When no TLS options are given")
+	tlsConfig, err := buildTLSConfig(nil)
+	assert.Nil(t, err, "Expected no error")
+	assert.Nil(t, tlsConfig.RootCAs, "Expected the system roots")
+
+	certPEM, keyPEM := testCertificate(t)
+	dir, _ := ioutil.TempDir("", "mgostore")
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, certPEM, 0600)
+
+	t.Log("When the CA is read from a file and the client certificate is inline")
+	tlsConfig, err = buildTLSConfig(&TLSConfig{
+		CAFile:     caFile,
+		CertPEM:    certPEM,
+		KeyPEM:     keyPEM,
+		ServerName: "mongo.internal",
+		MinVersion: tls.VersionTLS12,
+	})
+	assert.Nil(t, err, "Expected no error")
+	assert.NotNil(t, tlsConfig.RootCAs, "Expected the CA to be loaded")
+	assert.Equal(t, 1, len(tlsConfig.Certificates), "Expected the client certificate to be loaded")
+	assert.Equal(t, "mongo.internal", tlsConfig.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+
+	t.Log("When the CA is invalid")
+	_, err = buildTLSConfig(&TLSConfig{CAPEM: []byte("not a certificate")})
+	assert.Equal(t, ErrInvalidCACertificates, err)
+
+	t.Log("When the CA file does not exist")
+	_, err = buildTLSConfig(&TLSConfig{CAFile: filepath.Join(dir, "missing.pem")})
+	assert.NotNil(t, err, "Expected file not found error")
+
+	t.Log("When the client key is missing")
+	_, err = buildTLSConfig(&TLSConfig{CertPEM: certPEM})
+	assert.NotNil(t, err, "Expected invalid key pair error")
+}
+
+func Test_tlsKey(t *testing.T) {
+	certPEM, keyPEM := testCertificate(t)
+	key := tlsKey(&TLSConfig{CertPEM: certPEM, KeyPEM: keyPEM})
+	assert.NotContains(t, key, string(keyPEM), "Expected the private key to be hashed")
+	assert.Equal(t, key, tlsKey(&TLSConfig{CertPEM: certPEM, KeyPEM: keyPEM}))
+	assert.NotEqual(t, key, tlsKey(&TLSConfig{CertPEM: certPEM, KeyPEM: keyPEM, ServerName: "other"}))
+	assert.NotEqual(t, key, tlsKey(&TLSConfig{CertPEM: certPEM, KeyPEM: keyPEM, InsecureSkipVerify: true}))
+	assert.NotEqual(t, tlsKey(nil), tlsKey(&TLSConfig{}))
+}
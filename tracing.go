@@ -0,0 +1,224 @@
+package mgostore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Tracer starts a span around every operation, so that mongo calls show up in distributed traces.
+An adapter for eg. OpenTelemetry only needs to wrap its own tracer.
+*/
+type Tracer interface {
+	// StartSpan starts a span as a child of the span in ctx, if there is one
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// End ends the span, err is nil when the operation succeeded
+	End(err error)
+}
+
+// Attributes set on the span of every operation
+const (
+	SpanAttributeDB         = "db.name"
+	SpanAttributeCollection = "db.collection"
+	SpanAttributeOperation  = "db.operation"
+	// Shape of the filter, with all values left out
+	SpanAttributeFilter    = "db.filter"
+	SpanAttributeDocuments = "mgostore.documents"
+	SpanAttributeAttempts  = "mgostore.attempts"
+)
+
+// noopTracer is used when no Tracer is configured
+type noopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) End(err error) {}
+
+/*
+WithContext passes the context of the caller, which holds the parent span of the operation.
+
+	mgostore.Find(mam, mgostore.WithContext(ctx))
+*/
+func WithContext(ctx context.Context) Option {
+	return func(op *operation) {
+		op.ctx = ctx
+	}
+}
+
+// startSpan starts the span of the operation with the tracer of the config
+func (op *operation) startSpan() Span {
+	tracer := op.config.Tracer
+	if tracer == nil {
+		tracer = noopTracer{}
+	}
+	ctx := op.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracer.StartSpan(ctx, "mgostore."+op.name)
+	span.SetAttribute(SpanAttributeDB, op.config.DBName)
+	span.SetAttribute(SpanAttributeCollection, op.collectionName)
+	span.SetAttribute(SpanAttributeOperation, op.name)
+	if op.filter != nil {
+		span.SetAttribute(SpanAttributeFilter, filterShape(op.filter))
+	}
+	return span
+}
+
+// endSpan ends the span of the finished operation
+func (op *operation) endSpan(span Span, err error) {
+	span.SetAttribute(SpanAttributeDocuments, op.documents)
+	span.SetAttribute(SpanAttributeAttempts, op.attempt)
+	span.End(err)
+}
+
+/*
+filterShape returns the keys of the filter with all values replaced by ?,
+eg. {$or: [{age: {$gt: ?}}, {name: ?}]}. Keys of maps are sorted, so that the same query
+always has the same shape. Keys of bson.D keep their order, which is part of the query.
+*/
+func filterShape(v interface{}) string {
+	switch f := v.(type) {
+	case map[string]interface{}:
+		return filterShape(bson.M(f))
+	case bson.M:
+		keys := make([]string, 0, len(f))
+		for k := range f {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + ": " + filterShape(f[k])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case bson.D:
+		parts := make([]string, len(f))
+		for i, e := range f {
+			parts[i] = filterShape(e)
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case []bson.DocElem:
+		return filterShape(bson.D(f))
+	case bson.DocElem:
+		return f.Name + ": " + filterShape(f.Value)
+	case []bson.M:
+		parts := make([]string, len(f))
+		for i, m := range f {
+			parts[i] = filterShape(m)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case []bson.D:
+		parts := make([]string, len(f))
+		for i, d := range f {
+			parts[i] = filterShape(d)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case []interface{}:
+		parts := make([]string, len(f))
+		for i, e := range f {
+			parts[i] = filterShape(e)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return "?"
+	}
+}
+
+/*
+RecordingTracer is a Tracer which keeps all spans in memory, eg. to assert on them in tests.
+
+	tracer := &mgostore.RecordingTracer{}
+	config.Tracer = tracer
+	...
+	spans := tracer.Spans()
+*/
+type RecordingTracer struct {
+	mux   sync.Mutex
+	spans []*RecordedSpan
+}
+
+// RecordedSpan is a span recorded by a RecordingTracer
+type RecordedSpan struct {
+	Name string
+	// Span in the context the span was started with, when it was recorded by the same tracer
+	Parent     *RecordedSpan
+	Attributes map[string]interface{}
+	Start      time.Time
+	End        time.Time
+	Err        error
+	Ended      bool
+
+	tracer *RecordingTracer
+}
+
+type recordedSpanKey struct{}
+
+// StartSpan records a new span
+func (rt *RecordingTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	span := &RecordedSpan{Name: name, Attributes: map[string]interface{}{}, Start: time.Now(), tracer: rt}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*RecordedSpan); ok && parent.tracer == rt {
+		span.Parent = parent
+	}
+	rt.mux.Lock()
+	rt.spans = append(rt.spans, span)
+	rt.mux.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, span), &recordedSpanHandle{span}
+}
+
+// Spans returns copies of the spans recorded so far, in the order they were started
+func (rt *RecordingTracer) Spans() []RecordedSpan {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	spans := make([]RecordedSpan, len(rt.spans))
+	for i, s := range rt.spans {
+		spans[i] = *s
+		spans[i].Attributes = map[string]interface{}{}
+		for k, v := range s.Attributes {
+			spans[i].Attributes[k] = v
+		}
+	}
+	return spans
+}
+
+// Reset drops the recorded spans
+func (rt *RecordingTracer) Reset() {
+	rt.mux.Lock()
+	rt.spans = nil
+	rt.mux.Unlock()
+}
+
+// recordedSpanHandle is the Span returned for a RecordedSpan, it writes under the lock of the tracer
+type recordedSpanHandle struct {
+	span *RecordedSpan
+}
+
+func (h *recordedSpanHandle) SetAttribute(key string, value interface{}) {
+	h.span.tracer.mux.Lock()
+	h.span.Attributes[key] = value
+	h.span.tracer.mux.Unlock()
+}
+
+func (h *recordedSpanHandle) End(err error) {
+	h.span.tracer.mux.Lock()
+	h.span.End = time.Now()
+	h.span.Err = err
+	h.span.Ended = true
+	h.span.tracer.mux.Unlock()
+}
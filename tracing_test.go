@@ -0,0 +1,108 @@
+package mgostore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_filterShape(t *testing.T) {
+	filter := bson.M{
+		"name": "some name",
+		"$or": []bson.M{
+			{"age": bson.M{"$gt": 18}},
+			{"tags": bson.M{"$in": []interface{}{"a", "b"}}},
+		},
+	}
+	assert.Equal(t, "{$or: [{age: {$gt: ?}}, {tags: {$in: [?, ?]}}], name: ?}", filterShape(filter))
+
+	t.Log("When the filter is empty")
+	assert.Equal(t, "{}", filterShape(bson.M{}))
+
+	t.Log("When the filter is ordered")
+	ordered := bson.D{
+		{Name: "name", Value: "some name"},
+		{Name: "$or", Value: []bson.D{
+			{{Name: "age", Value: bson.D{{Name: "$gt", Value: 18}}}},
+			{{Name: "tags", Value: bson.M{"$in": []interface{}{"a", bson.D{{Name: "b", Value: 1}}}}}},
+		}},
+	}
+	assert.Equal(t, "{name: ?, $or: [{age: {$gt: ?}}, {tags: {$in: [?, {b: ?}]}}]}", filterShape(ordered),
+		"Expected the keys to keep their order and no values")
+
+	t.Log("When the filter is a single element")
+	assert.Equal(t, "email: ?", filterShape(bson.DocElem{Name: "email", Value: "secret@example.com"}))
+}
+
+func TestRecordingTracer(t *testing.T) {
+	rt := &RecordingTracer{}
+	ctx, parent := rt.StartSpan(context.Background(), "parent")
+	_, child := rt.StartSpan(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.End(errors.New("failed"))
+
+	spans := rt.Spans()
+	assert.Equal(t, 2, len(spans))
+	assert.Nil(t, spans[0].Parent, "Expected the first span to be a root span")
+	assert.False(t, spans[0].Ended)
+	assert.Equal(t, "child", spans[1].Name)
+	assert.Equal(t, "parent", spans[1].Parent.Name, "Expected the parent from the context")
+	assert.Equal(t, "value", spans[1].Attributes["key"])
+	assert.Equal(t, "failed", spans[1].Err.Error())
+	assert.True(t, spans[1].Ended)
+
+	parent.End(nil)
+	assert.True(t, rt.Spans()[0].Ended)
+
+	t.Log("When the tracer is reset")
+	rt.Reset()
+	assert.Equal(t, 0, len(rt.Spans()))
+}
+
+func Test_operationSpan(t *testing.T) {
+	rt := &RecordingTracer{}
+	config := &MongoConfig{
+		Servers: "tracing_invalid_server",
+		DBName:  "test",
+		Timeout: 10 * time.Millisecond,
+		Tracer:  rt,
+	}
+	ctx, parent := rt.StartSpan(context.Background(), "request")
+	defer parent.End(nil)
+
+	op := newOperation(config, "mock_models", []Option{WithContext(ctx)})
+	op.name = "find_by"
+	op.filter = bson.M{"secret_field": "[REDACTED]"}
+	err := op.run(func(c *mgo.Collection) error {
+		return nil
+	})
+
+	spans := rt.Spans()
+	assert.Equal(t, 2, len(spans))
+	span := spans[1]
+	assert.Equal(t, "mgostore.find_by", span.Name)
+	assert.Equal(t, "request", span.Parent.Name, "Expected the span to be a child of the span in the context")
+	assert.Equal(t, map[string]interface{}{
+		SpanAttributeDB:         "test",
+		SpanAttributeCollection: "mock_models",
+		SpanAttributeOperation:  "find_by",
+		SpanAttributeFilter:     "{secret_field: ?}",
+		SpanAttributeDocuments:  0,
+		SpanAttributeAttempts:   1,
+	}, span.Attributes)
+	assert.Equal(t, err, span.Err)
+	assert.True(t, span.Ended)
+
+	t.Log("When no tracer is configured")
+	config.Tracer = nil
+	err = newOperation(config, "mock_models", nil).run(func(c *mgo.Collection) error {
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, len(rt.Spans()))
+}
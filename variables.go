@@ -4,13 +4,33 @@ import (
 	"errors"
 	"sync"
 
+	"github.com/gsingharoy/mgostore/lib"
 	mgo "gopkg.in/mgo.v2"
 )
 
+// Master sessions and circuit breakers by the identity of their connection
 var (
-	sessionMap  = make(map[string]*mgo.Session)
-	sessionWMux sync.Mutex
-	sessionRMux sync.RWMutex
+	registry    = make(map[string]*registryEntry)
+	breakers    = make(map[breakerKey]*circuitBreaker)
+	registryMux sync.Mutex
+)
+
+// Whether mgo counts sockets for FetchStats
+var (
+	socketStatsEnabled bool
+	socketStatsMux     sync.Mutex
+)
+
+// Ciphers which can be selected with the encrypt tag
+var (
+	cipherRegistry = map[string]Cipher{
+		"aes":               aesCipher{},
+		"aes-gcm":           aesGCMCipher{},
+		"chacha20":          chaCha20Poly1305Cipher{},
+		"chacha20-poly1305": chaCha20Poly1305Cipher{},
+		"aes-cfb":           aesCFBCipher{},
+	}
+	cipherMux sync.RWMutex
 )
 
 // All error variables here
@@ -19,3 +39,29 @@ var ErrInvalidId = errors.New("invalid id")
 var ErrRecordNotFound = mgo.ErrNotFound
 var ErrMongoCollectionNotFetched = errors.New("mongo collection not fetched")
 var ErrMissingCryptoSecret = errors.New("missing crypto secret")
+var ErrCiphertextMismatch = errors.New("encrypted value does not belong to this document and field")
+var ErrUnknownHashAlgorithm = errors.New("unknown hash algorithm")
+var ErrFieldNotHashed = errors.New("field is not a hashed field")
+var ErrHashMismatch = lib.ErrHashMismatch
+var ErrUnknownCipher = errors.New("unknown cipher in encrypt tag")
+var ErrUnauthenticatedCiphertext = errors.New("value was stored with AES-CFB, set CryptoConfig.LegacyCFB to decrypt it")
+var ErrUnauthenticatedCipher = errors.New("cipher does not authenticate values, set ReencryptOptions.AllowUnauthenticated to decrypt with it")
+var ErrUnknownReencryptMode = errors.New("unknown re-encrypt mode")
+var ErrInvalidCACertificates = errors.New("no valid CA certificates found in PEM")
+var ErrMissingCredentials = errors.New("missing mongo credentials")
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+var ErrDuplicateKey = errors.New("duplicate key")
+var ErrDecryption = errors.New("decryption failed")
+var ErrTimeout = errors.New("operation timed out")
+var ErrConnection = errors.New("connection to mongo failed")
+var ErrValidation = errors.New("document failed validation")
+var ErrUnsupportedOperator = errors.New("query operator is not supported by the memory store")
+var ErrUnknownRelation = errors.New("field is not a relation declared by a ref tag")
+var ErrStoredRelation = errors.New("relation field would be stored with the model, tag it with bson:\"-\"")
+var ErrDeleteRestricted = errors.New("model is referenced by a restrict delete rule")
+var ErrInvalidDeleteRule = errors.New("ondelete in ref tag is unknown or not on a has-many relation")
+var ErrAttachmentNotStored = errors.New("attachment has not been stored")
+var ErrAttachmentConsumed = errors.New("content of the attachment was read by a failed save, set a new attachment")
+var ErrResumePointLost = errors.New("oplog no longer holds the changes after the resume timestamp")
+var ErrCollectionExists = errors.New("collection already exists")
+var ErrCollectionNotFound = errors.New("collection does not exist")
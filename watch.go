@@ -0,0 +1,334 @@
+package mgostore
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	oplogDB         = "local"
+	oplogCollection = "oplog.rs"
+)
+
+// ChangeOperation is the kind of change of a ChangeEvent
+type ChangeOperation string
+
+const (
+	ChangeInsert ChangeOperation = "insert"
+	ChangeUpdate ChangeOperation = "update"
+	ChangeDelete ChangeOperation = "delete"
+)
+
+// oplogOperations maps the op of the oplog entries to the changes they describe
+var oplogOperations = map[string]ChangeOperation{
+	"i": ChangeInsert,
+	"u": ChangeUpdate,
+	"d": ChangeDelete,
+}
+
+/*
+ChangeEvent is a change of a document of the collection of the model T, as read from the oplog.
+*/
+type ChangeEvent[T any] struct {
+	Operation ChangeOperation
+	// ID of the changed document
+	ID interface{}
+	// Position of the change in the oplog, to resume after it
+	Timestamp bson.MongoTimestamp
+	// The decrypted document after the change. It is set for inserts, for updates which replaced
+	// the document and, with LookupUpdates, for the other updates unless the document is gone.
+	// It is nil for deletes.
+	Document *T
+	// The modifiers of updates which did not replace the document, eg. {"$set": {"name": "x"}}.
+	// Their format depends on the version of the server and encrypted values are not decrypted.
+	Update bson.M
+}
+
+// WatchOptions configures a ChangeStream
+type WatchOptions struct {
+	// Timestamp of the last change which was delivered, to resume after it.
+	// When zero the Checkpoint is loaded, without either only new changes are delivered.
+	// The stream fails with ErrResumePointLost when the oplog no longer reaches back to it
+	ResumeAfter bson.MongoTimestamp
+	// Stores the timestamp of every delivered change
+	Checkpoint Checkpoint
+	// Reads the current document for updates which did not replace the document
+	LookupUpdates bool
+	// How long the cursor waits for new changes before it checks the context. Defaults to 1s
+	PollTimeout time.Duration
+	// Pause before the cursor is opened again after it died or failed with a transient error. Defaults to 1s
+	RetryBackoff time.Duration
+	// Number of events buffered in the channel
+	Buffer int
+}
+
+/*
+ChangeStream delivers the changes of a collection.
+C is closed when the context is cancelled or the stream failed permanently, see Err.
+*/
+type ChangeStream[T any] struct {
+	C <-chan ChangeEvent[T]
+
+	mux           sync.Mutex
+	lastTimestamp bson.MongoTimestamp
+	err           error
+}
+
+// Err returns the error which stopped the stream, nil when it was cancelled
+func (cs *ChangeStream[T]) Err() error {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	return cs.err
+}
+
+// LastTimestamp returns the timestamp of the last delivered change, to resume a new stream after it
+func (cs *ChangeStream[T]) LastTimestamp() bson.MongoTimestamp {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	return cs.lastTimestamp
+}
+
+/*
+Watch tails the oplog of the replica set for the inserts, updates and deletes of the collection
+of the model T, by any process. The cursor is opened again after timeouts and transient errors,
+resuming after the last delivered change. Changes made by transactions are not delivered.
+The user of the DB config needs to be able to read the local database.
+
+	cs := mgostore.Watch[User](ctx, mgostore.WatchOptions{
+		Checkpoint: &mgostore.FileCheckpoint{Path: "users.checkpoint"},
+	})
+	for e := range cs.C {
+		cache.Invalidate(e.ID)
+	}
+	if err := cs.Err(); err != nil {
+		...
+	}
+*/
+func Watch[T any, P ModelPointer[T]](ctx context.Context, opts WatchOptions) *ChangeStream[T] {
+	if opts.PollTimeout <= 0 {
+		opts.PollTimeout = defaultPollTimeout
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultRetryBackoff
+	}
+	ch := make(chan ChangeEvent[T], opts.Buffer)
+	cs := &ChangeStream[T]{C: ch, lastTimestamp: opts.ResumeAfter}
+	go watch[T, P](ctx, cs, ch, opts)
+	return cs
+}
+
+// watch tails the oplog until the context is cancelled or a permanent error occurs
+func watch[T any, P ModelPointer[T]](ctx context.Context, cs *ChangeStream[T], ch chan<- ChangeEvent[T], opts WatchOptions) {
+	defer close(ch)
+	var err error
+	if cs.LastTimestamp() == 0 && opts.Checkpoint != nil {
+		var lastTimestamp interface{}
+		if lastTimestamp, err = opts.Checkpoint.Load(); err == nil && lastTimestamp != nil {
+			cs.mux.Lock()
+			cs.lastTimestamp, _ = lastTimestamp.(bson.MongoTimestamp)
+			cs.mux.Unlock()
+		}
+	}
+	if err == nil {
+		err = retryTail(ctx, opts.RetryBackoff, func() error {
+			return tailOplog[T, P](ctx, cs, ch, opts)
+		})
+	}
+	cs.mux.Lock()
+	cs.err = err
+	cs.mux.Unlock()
+}
+
+// tailOplog delivers changes on a single session, it returns nil when the context is cancelled
+func tailOplog[T any, P ModelPointer[T]](ctx context.Context, cs *ChangeStream[T], ch chan<- ChangeEvent[T], opts WatchOptions) error {
+	prototype := P(new(T))
+	config := prototype.DBConfig()
+	session, err := newSession(config)
+	if session != nil {
+		defer session.Close()
+	}
+	if err != nil {
+		return err
+	}
+	c := session.DB(config.DBName).C(prototype.CollectionName())
+	oplog := session.DB(oplogDB).C(oplogCollection)
+	if cs.LastTimestamp() == 0 {
+		// Only changes from now on
+		var latest oplogEntry
+		if err := oplog.Find(nil).Sort("-$natural").One(&latest); err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		cs.mux.Lock()
+		cs.lastTimestamp = latest.Timestamp
+		cs.mux.Unlock()
+	} else if err := checkResumePoint(oplog, cs.LastTimestamp()); err != nil {
+		return err
+	}
+	lookup := func(id interface{}) (*T, error) {
+		m := P(new(T))
+		if err := c.FindId(id).One(m); err != nil {
+			return nil, err
+		}
+		return (*T)(m), nil
+	}
+	if !opts.LookupUpdates {
+		lookup = nil
+	}
+
+	namespace := config.DBName + "." + prototype.CollectionName()
+	iter := oplog.Find(oplogQuery(namespace, cs.LastTimestamp())).LogReplay().Tail(opts.PollTimeout)
+	for {
+		var entry oplogEntry
+		for iter.Next(&entry) {
+			e, err := decodeChange[T, P](entry, lookup)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			select {
+			case ch <- e:
+				cs.mux.Lock()
+				cs.lastTimestamp = e.Timestamp
+				cs.mux.Unlock()
+			case <-ctx.Done():
+				iter.Close()
+				return nil
+			}
+			if opts.Checkpoint != nil {
+				if err := opts.Checkpoint.Save(e.Timestamp); err != nil {
+					iter.Close()
+					return err
+				}
+			}
+			entry = oplogEntry{}
+		}
+		if ctx.Err() != nil {
+			iter.Close()
+			return nil
+		}
+		if iter.Timeout() {
+			continue
+		}
+		// The cursor died or failed
+		if err := iter.Close(); err != nil {
+			return err
+		}
+		if !sleepContext(ctx, opts.RetryBackoff) {
+			return nil
+		}
+		if err := checkResumePoint(oplog, cs.LastTimestamp()); err != nil {
+			return err
+		}
+		iter = oplog.Find(oplogQuery(namespace, cs.LastTimestamp())).LogReplay().Tail(opts.PollTimeout)
+	}
+}
+
+/*
+checkResumePoint returns ErrResumePointLost when the oplog no longer holds the changes after the
+timestamp, as older entries were removed to make room. Resuming would silently skip them.
+*/
+func checkResumePoint(oplog *mgo.Collection, lastTimestamp bson.MongoTimestamp) error {
+	var oldest oplogEntry
+	if err := oplog.Find(nil).Sort("$natural").One(&oldest); err == mgo.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if resumePointLost(oldest.Timestamp, lastTimestamp) {
+		return ErrResumePointLost
+	}
+	return nil
+}
+
+/*
+resumePointLost reports if changes after the timestamp might be missing from an oplog which starts
+at the oldest timestamp. The entry of the timestamp itself is kept as long as nothing after it is gone.
+*/
+func resumePointLost(oldest bson.MongoTimestamp, lastTimestamp bson.MongoTimestamp) bool {
+	return oldest > lastTimestamp
+}
+
+// oplogEntry is an entry of the oplog of a replica set
+type oplogEntry struct {
+	Timestamp bson.MongoTimestamp `bson:"ts"`
+	Operation string              `bson:"op"`
+	Namespace string              `bson:"ns"`
+	// The document for inserts and replacements, the modifiers for updates, the _id for deletes
+	Object bson.Raw `bson:"o"`
+	// The _id of the document for updates
+	Query bson.M `bson:"o2,omitempty"`
+}
+
+// oplogQuery selects the changes of the namespace after the timestamp
+func oplogQuery(namespace string, after bson.MongoTimestamp) bson.M {
+	return bson.M{
+		"ts": bson.M{"$gt": after},
+		"ns": namespace,
+		"op": bson.M{"$in": []string{"i", "u", "d"}},
+	}
+}
+
+/*
+decodeChange decodes an oplog entry into a change event, decrypting its document.
+lookup reads the current document for updates with modifiers, it is skipped when nil.
+*/
+func decodeChange[T any, P ModelPointer[T]](entry oplogEntry, lookup func(id interface{}) (*T, error)) (ChangeEvent[T], error) {
+	e := ChangeEvent[T]{Operation: oplogOperations[entry.Operation], Timestamp: entry.Timestamp}
+	var object bson.M
+	if err := entry.Object.Unmarshal(&object); err != nil {
+		return e, err
+	}
+	switch e.Operation {
+	case ChangeInsert:
+		e.ID = object["_id"]
+	default:
+		e.ID = entry.Query["_id"]
+		if e.ID == nil {
+			e.ID = object["_id"]
+		}
+	}
+	if e.Operation == ChangeDelete {
+		return e, nil
+	}
+
+	var doc *T
+	if e.Operation == ChangeUpdate && isModifierDocument(object) {
+		e.Update = object
+		if lookup == nil {
+			return e, nil
+		}
+		var err error
+		if doc, err = lookup(e.ID); err == mgo.ErrNotFound {
+			// Deleted since
+			return e, nil
+		} else if err != nil {
+			return e, err
+		}
+	} else {
+		doc = new(T)
+		if err := entry.Object.Unmarshal(doc); err != nil {
+			return e, err
+		}
+	}
+	bindAttachments(P(doc), gridFSFiles{})
+	if err := decryptFields(P(doc)); err != nil {
+		return e, err
+	}
+	e.Document = doc
+	return e, nil
+}
+
+// isModifierDocument reports if the object of an update entry holds modifiers instead of a replacement
+func isModifierDocument(object bson.M) bool {
+	for k := range object {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package mgostore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// oplogTestEntry returns an oplog entry with the object marshalled the way the server stores it
+func oplogTestEntry(t *testing.T, op string, object interface{}, query bson.M) oplogEntry {
+	data, err := bson.Marshal(object)
+	assert.Nil(t, err)
+	return oplogEntry{
+		Timestamp: bson.MongoTimestamp(42),
+		Operation: op,
+		Namespace: "test.notifications",
+		Object:    bson.Raw{Kind: 0x03, Data: data},
+		Query:     query,
+	}
+}
+
+func Test_decodeChange(t *testing.T) {
+	setTestEnvVars()
+	id := bson.NewObjectId()
+	m := &notificationModel{ID: id, UserID: "u1", Message: "hello"}
+	assert.Nil(t, encryptFields(m))
+
+	t.Log("When a document is inserted")
+	e, err := decodeChange[notificationModel](oplogTestEntry(t, "i", m, nil), nil)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, ChangeInsert, e.Operation)
+	assert.Equal(t, id, e.ID)
+	assert.Equal(t, bson.MongoTimestamp(42), e.Timestamp)
+	assert.Equal(t, "hello", e.Document.Message, "Expected the document to be decrypted")
+
+	t.Log("When a document is replaced")
+	e, err = decodeChange[notificationModel](oplogTestEntry(t, "u", m, bson.M{"_id": id}), nil)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, ChangeUpdate, e.Operation)
+	assert.Equal(t, "hello", e.Document.Message, "Expected the replacement to be the document")
+	assert.Nil(t, e.Update)
+
+	t.Log("When a document is updated with modifiers")
+	modifiers := bson.M{"$set": bson.M{"user_id": "u2"}}
+	e, err = decodeChange[notificationModel](oplogTestEntry(t, "u", modifiers, bson.M{"_id": id}), nil)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, id, e.ID)
+	assert.Nil(t, e.Document, "Expected no document without a lookup")
+	assert.Equal(t, bson.M{"$set": bson.M{"user_id": "u2"}}, e.Update)
+
+	t.Log("When a document updated with modifiers is looked up")
+	var lookedUp interface{}
+	lookup := func(id interface{}) (*notificationModel, error) {
+		lookedUp = id
+		stored := *m
+		return &stored, nil
+	}
+	e, err = decodeChange[notificationModel](oplogTestEntry(t, "u", modifiers, bson.M{"_id": id}), lookup)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, id, lookedUp, "Expected the document to be looked up by its ID")
+	assert.Equal(t, "hello", e.Document.Message, "Expected the looked up document to be decrypted")
+
+	t.Log("When a document updated with modifiers is gone")
+	gone := func(id interface{}) (*notificationModel, error) { return nil, mgo.ErrNotFound }
+	e, err = decodeChange[notificationModel](oplogTestEntry(t, "u", modifiers, bson.M{"_id": id}), gone)
+	assert.Nil(t, err, "Expected no error")
+	assert.Nil(t, e.Document)
+
+	t.Log("When a document is deleted")
+	e, err = decodeChange[notificationModel](oplogTestEntry(t, "d", bson.M{"_id": id}, nil), lookup)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, ChangeDelete, e.Operation)
+	assert.Equal(t, id, e.ID)
+	assert.Nil(t, e.Document)
+
+	t.Log("When the document cannot be decrypted")
+	m.Message = "not encrypted"
+	_, err = decodeChange[notificationModel](oplogTestEntry(t, "i", m, nil), nil)
+	assert.True(t, errors.Is(err, ErrDecryption), "Expected a decryption error")
+}
+
+func Test_oplogQuery(t *testing.T) {
+	assert.Equal(t, bson.M{
+		"ts": bson.M{"$gt": bson.MongoTimestamp(42)},
+		"ns": "test.notifications",
+		"op": bson.M{"$in": []string{"i", "u", "d"}},
+	}, oplogQuery("test.notifications", 42))
+}
+
+func Test_resumePointLost(t *testing.T) {
+	assert.False(t, resumePointLost(40, 42), "Expected older entries to be kept")
+	assert.False(t, resumePointLost(42, 42), "Expected the entry of the last change to be enough")
+
+	t.Log("When the oplog was truncated past the last change")
+	assert.True(t, resumePointLost(43, 42))
+}
+
+func TestWatchCheckpoint(t *testing.T) {
+	setTestEnvVars()
+	os.Setenv("MONGODB_SERVERS", "watch_invalid_server")
+	defer setTestEnvVars()
+	dir, _ := ioutil.TempDir("", "mgostore")
+	defer os.RemoveAll(dir)
+	c := &FileCheckpoint{Path: filepath.Join(dir, "checkpoint")}
+	assert.Nil(t, c.Save(bson.MongoTimestamp(6000000000000000001)))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	cs := Watch[notificationModel](ctx, WatchOptions{Checkpoint: c, RetryBackoff: 10 * time.Millisecond})
+	_, open := <-cs.C
+	assert.False(t, open, "Expected the channel to be closed when the context is done")
+	assert.Nil(t, cs.Err(), "Expected transient errors to be retried until the context is done")
+	assert.Equal(t, bson.MongoTimestamp(6000000000000000001), cs.LastTimestamp(), "Expected to resume from the checkpoint")
+}